@@ -0,0 +1,166 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/godbus/dbus"
+)
+
+// AcquireNotify acquires a socket on which the characteristic's
+// notifications are delivered directly, rather than as D-Bus signals,
+// and returns it together with the link's MTU.
+// Each Read returns a single notification, so the buffer should be
+// at least MTU bytes long.  Closing the reader disables notifications.
+//
+// If BlueZ refuses to provide a socket, AcquireNotify falls back
+// to a reader that receives notifications through Notifications.
+// A notification longer than the fallback reader's buffer is not split
+// across Reads: the Read returns the bytes that fit together with
+// io.ErrShortBuffer, and the rest of the notification is discarded.
+func (char *blob) AcquireNotify() (io.ReadCloser, uint16, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return char.AcquireNotifyContext(ctx)
+}
+
+// AcquireNotifyContext performs AcquireNotify, using the given context.
+// The context applies only to acquiring the socket, not to the reader.
+func (char *blob) AcquireNotifyContext(ctx context.Context) (io.ReadCloser, uint16, error) {
+	err := char.checkFlags("notify", notifyFlags)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, mtu, err := char.acquire(ctx, "AcquireNotify")
+	if err == nil {
+		return f, mtu, nil
+	}
+	if !acquireRefused(err) {
+		return nil, 0, err
+	}
+	life, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-started:
+		}
+	}()
+	values, err := char.Notifications(life)
+	close(started)
+	if err != nil {
+		cancel()
+		return nil, 0, err
+	}
+	return &notifyReader{values: values, cancel: cancel}, char.MTU(), nil
+}
+
+// AcquireWrite acquires a socket on which values can be written
+// to the characteristic without response, rather than through D-Bus calls,
+// and returns it together with the link's MTU.
+// Each Write sends a single value of at most MTU - 3 bytes.
+//
+// If BlueZ refuses to provide a socket, AcquireWrite falls back
+// to a writer that uses WriteValueWithOptions with the default
+// write type for the characteristic.
+func (char *blob) AcquireWrite() (io.WriteCloser, uint16, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return char.AcquireWriteContext(ctx)
+}
+
+// AcquireWriteContext performs AcquireWrite, using the given context.
+// The context applies only to acquiring the socket, not to the writer.
+func (char *blob) AcquireWriteContext(ctx context.Context) (io.WriteCloser, uint16, error) {
+	err := char.checkFlags("write without response", FlagWriteWithoutResponse)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, mtu, err := char.acquire(ctx, "AcquireWrite")
+	if err == nil {
+		return f, mtu, nil
+	}
+	if !acquireRefused(err) {
+		return nil, 0, err
+	}
+	return valueWriter{char: char, opts: WriteOptions{Type: char.writeType(WriteDefault)}}, char.MTU(), nil
+}
+
+// errNoFD indicates that an Acquire method did not return a file descriptor,
+// as happens when the D-Bus transport cannot pass them.
+var errNoFD = errors.New("no file descriptor received")
+
+// acquire calls the given Acquire method and wraps the resulting
+// file descriptor.  The MTU is recorded in the object cache.
+func (char *blob) acquire(ctx context.Context, method string) (*os.File, uint16, error) {
+	call := char.callvContext(ctx, method, Properties{})
+	if call.Err != nil {
+		return nil, 0, call.Err
+	}
+	if len(call.Body) != 2 {
+		return nil, 0, errNoFD
+	}
+	fd, ok := call.Body[0].(dbus.UnixFD)
+	if !ok {
+		return nil, 0, errNoFD
+	}
+	mtu, _ := call.Body[1].(uint16)
+	char.conn.changeProperties(char.path, char.iface, Properties{"MTU": dbus.MakeVariant(mtu)}, nil)
+	return os.NewFile(uintptr(fd), string(char.path)), mtu, nil
+}
+
+// acquireRefused reports whether an Acquire method failed because
+// BlueZ does not support or permit it for the characteristic,
+// rather than because of a timeout, cancellation, or other failure.
+func acquireRefused(err error) bool {
+	return errors.Is(err, ErrNotSupported) || errors.Is(err, ErrNotPermitted) || err == errNoFD
+}
+
+// notifyReader reads notifications from a Notifications channel.
+type notifyReader struct {
+	values <-chan Notification
+	cancel context.CancelFunc
+}
+
+func (r *notifyReader) Read(p []byte) (int, error) {
+	n, ok := <-r.values
+	if !ok {
+		return 0, io.EOF
+	}
+	m := copy(p, n.Value)
+	if m < len(n.Value) {
+		return m, io.ErrShortBuffer
+	}
+	return m, nil
+}
+
+// Close disables notifications and waits for the channel to be closed.
+func (r *notifyReader) Close() error {
+	r.cancel()
+	for range r.values {
+	}
+	return nil
+}
+
+// valueWriter writes each value to a characteristic with the given options.
+type valueWriter struct {
+	char *blob
+	opts WriteOptions
+}
+
+func (w valueWriter) Write(p []byte) (int, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	err := w.char.WriteValueWithOptionsContext(ctx, p, w.opts)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (valueWriter) Close() error {
+	return nil
+}
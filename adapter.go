@@ -1,7 +1,12 @@
 package ble
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math"
+	"path"
+	"strings"
 	"time"
 )
 
@@ -20,10 +25,38 @@ const (
 //
 // SetDiscoveryFilter sets the discovery filter to require
 // LE transport and the given UUIDs.
+// ApplyDiscoveryFilter sets a discovery filter with other options.
+// GetDiscoveryFilters returns the filter keys that are supported.
 //
 // Discover performs discovery for a device with the given UUIDs,
 // for at most the specified timeout, or indefinitely if timeout is 0.
 // See also the Discover method of the ObjectCache type.
+//
+// DiscoverDevice performs discovery on the adapter for a device
+// with the given address (if nonempty) and UUIDs, and returns it.
+// DiscoverDeviceContext is the same but stops when the context is done.
+//
+// DiscoverMatching performs discovery on the adapter with the given filter
+// for a device satisfying the given Matcher, and returns it.
+//
+// DiscoverAll performs discovery on the adapter with the given filter
+// and returns all the devices satisfying the given Matcher.
+//
+// Scan performs discovery until the context is done, delivering an event
+// for every device found and every update of its properties.
+//
+// RegisterApplication exports local GATT services and registers them
+// with the adapter, so it can act as a peripheral.
+//
+// The GetDevice methods are like those of the Connection type,
+// but only find devices belonging to the adapter.
+//
+// The Set methods change the adapter's properties,
+// and Powered, Discovering, Roles, Modalias, and ExperimentalFeatures
+// get their current values from BlueZ.
+//
+// The Context variants of these methods use the given context
+// to bound the operation, instead of the default call timeout.
 type Adapter interface {
 	BaseObject
 
@@ -33,24 +66,212 @@ type Adapter interface {
 	SetDiscoveryFilter(uuids ...string) error
 
 	Discover(timeout time.Duration, uuids ...string) error
+	DiscoverDevice(address Address, uuids ...string) (Device, error)
+
+	StartDiscoveryContext(context.Context) error
+	StopDiscoveryContext(context.Context) error
+	RemoveDeviceContext(context.Context, Device) error
+	SetDiscoveryFilterContext(ctx context.Context, uuids ...string) error
+	ApplyDiscoveryFilter(context.Context, DiscoveryFilter) error
+
+	GetDiscoveryFilters() ([]string, error)
+	GetDiscoveryFiltersContext(context.Context) ([]string, error)
+
+	DiscoverContext(ctx context.Context, uuids ...string) error
+	DiscoverDeviceContext(ctx context.Context, address Address, uuids ...string) (Device, error)
+	DiscoverMatching(context.Context, DiscoveryFilter, Matcher) (Device, error)
+	DiscoverAll(ctx context.Context, filter DiscoveryFilter, match Matcher, limit int) ([]Device, error)
+	Scan(context.Context, DiscoveryFilter) (<-chan ScanEvent, error)
+
+	Address() Address
+	AddressType() string
+	Alias() string
+
+	Powered() (bool, error)
+	Discovering() (bool, error)
+	Roles() ([]string, error)
+	Modalias() (string, error)
+	ExperimentalFeatures() ([]string, error)
+
+	SetPowered(bool) error
+	SetDiscoverable(bool) error
+	SetDiscoverableTimeout(time.Duration) error
+	SetPairable(bool) error
+	SetPairableTimeout(time.Duration) error
+	SetAlias(string) error
+
+	RegisterApplication(...*LocalService) (*Application, error)
+	RegisterApplicationContext(context.Context, ...*LocalService) (*Application, error)
+
+	GetDeviceByAddress(Address) (Device, error)
+	GetDeviceByName(string) (Device, error)
+	GetDeviceByUUID(uuids ...string) (Device, error)
+}
+
+// Adapters returns all the Adapters in the object cache, in path order.
+func (conn *Connection) Adapters() []Adapter {
+	found := conn.findObjects(adapterInterface, func(_ *blob) bool { return true })
+	adapters := make([]Adapter, len(found))
+	for i, adapter := range found {
+		adapters[i] = adapter
+	}
+	return adapters
 }
 
 // GetAdapter finds an Adapter in the object cache and returns it.
+// If there is more than one, it returns an error satisfying
+// errors.Is(err, ErrAmbiguous); use GetAdapterByName or GetAdapterByAddress
+// to choose one.
 func (conn *Connection) GetAdapter() (Adapter, error) {
 	return conn.findObject(adapterInterface, func(_ *blob) bool { return true })
 }
 
+// GetAdapterByName finds the Adapter with the given name, such as "hci1".
+// This is the last element of its D-Bus path,
+// not the value of its Name property (which is usually the host name).
+func (conn *Connection) GetAdapterByName(name string) (Adapter, error) {
+	adapter, err := conn.findObject(adapterInterface, func(adapter *blob) bool {
+		return path.Base(string(adapter.path)) == name
+	})
+	if err != nil {
+		err = fmt.Errorf("%w with name %s", err, name)
+	}
+	return adapter, err
+}
+
+// GetAdapterByAddress finds the Adapter with the given address.
+func (conn *Connection) GetAdapterByAddress(address Address) (Adapter, error) {
+	addr := Address(strings.ToUpper(string(address)))
+	adapter, err := conn.findObject(adapterInterface, func(adapter *blob) bool {
+		return adapter.Address() == addr
+	})
+	if err != nil {
+		err = fmt.Errorf("%w with address %s", err, addr)
+	}
+	return adapter, err
+}
+
 func (adapter *blob) StartDiscovery() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.StartDiscoveryContext(ctx)
+}
+
+func (adapter *blob) StartDiscoveryContext(ctx context.Context) error {
 	log.Printf("%s: starting discovery", adapter.Name())
-	return adapter.call("StartDiscovery")
+	return adapter.callContext(ctx, "StartDiscovery")
 }
 
 func (adapter *blob) StopDiscovery() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.StopDiscoveryContext(ctx)
+}
+
+func (adapter *blob) StopDiscoveryContext(ctx context.Context) error {
 	log.Printf("%s: stopping discovery", adapter.Name())
-	return adapter.call("StopDiscovery")
+	return adapter.callContext(ctx, "StopDiscovery")
 }
 
 func (adapter *blob) RemoveDevice(device Device) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.RemoveDeviceContext(ctx, device)
+}
+
+func (adapter *blob) RemoveDeviceContext(ctx context.Context, device Device) error {
 	log.Printf("%s: removing device %s", adapter.Name(), device.Name())
-	return adapter.call("RemoveDevice", device.Path())
+	return adapter.callContext(ctx, "RemoveDevice", device.Path())
+}
+
+func (adapter *blob) GetDeviceByAddress(address Address) (Device, error) {
+	return adapter.conn.getDeviceByAddress(adapter.path, address)
+}
+
+func (adapter *blob) GetDeviceByName(name string) (Device, error) {
+	return adapter.conn.getDeviceByName(adapter.path, name)
+}
+
+func (adapter *blob) GetDeviceByUUID(uuids ...string) (Device, error) {
+	return adapter.conn.getDeviceByUUID(adapter.path, uuids...)
+}
+
+func (adapter *blob) Powered() (bool, error) {
+	var powered bool
+	err := adapter.getProperty("Powered", &powered)
+	return powered, err
+}
+
+func (adapter *blob) Discovering() (bool, error) {
+	var discovering bool
+	err := adapter.getProperty("Discovering", &discovering)
+	return discovering, err
+}
+
+func (adapter *blob) Roles() ([]string, error) {
+	var roles []string
+	err := adapter.getProperty("Roles", &roles)
+	return roles, err
+}
+
+func (adapter *blob) Modalias() (string, error) {
+	var modalias string
+	err := adapter.getProperty("Modalias", &modalias)
+	return modalias, err
+}
+
+func (adapter *blob) ExperimentalFeatures() ([]string, error) {
+	var features []string
+	err := adapter.getProperty("ExperimentalFeatures", &features)
+	return features, err
+}
+
+func (adapter *blob) SetPowered(powered bool) error {
+	log.Printf("%s: setting powered %v", adapter.Name(), powered)
+	return adapter.setProperty("Powered", powered)
+}
+
+func (adapter *blob) SetDiscoverable(discoverable bool) error {
+	return adapter.setProperty("Discoverable", discoverable)
+}
+
+// SetDiscoverableTimeout sets how long the adapter remains discoverable,
+// rounded up to a whole number of seconds.  A timeout of 0 means forever.
+func (adapter *blob) SetDiscoverableTimeout(timeout time.Duration) error {
+	secs, err := timeoutSeconds(timeout)
+	if err != nil {
+		return err
+	}
+	return adapter.setProperty("DiscoverableTimeout", secs)
+}
+
+func (adapter *blob) SetPairable(pairable bool) error {
+	return adapter.setProperty("Pairable", pairable)
+}
+
+// SetPairableTimeout sets how long the adapter remains pairable,
+// rounded up to a whole number of seconds.  A timeout of 0 means forever.
+func (adapter *blob) SetPairableTimeout(timeout time.Duration) error {
+	secs, err := timeoutSeconds(timeout)
+	if err != nil {
+		return err
+	}
+	return adapter.setProperty("PairableTimeout", secs)
+}
+
+// timeoutSeconds converts a timeout to whole seconds, rounding up
+// so that a short timeout does not become 0 (which BlueZ treats as forever).
+func timeoutSeconds(timeout time.Duration) (uint32, error) {
+	secs := timeout / time.Second
+	if timeout%time.Second != 0 {
+		secs++
+	}
+	if timeout < 0 || secs > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: timeout %v", ErrInvalidArguments, timeout)
+	}
+	return uint32(secs), nil
+}
+
+func (adapter *blob) SetAlias(alias string) error {
+	return adapter.setProperty("Alias", alias)
 }
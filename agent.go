@@ -0,0 +1,243 @@
+package ble
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	agentInterface        = "org.bluez.Agent1"
+	agentManagerInterface = "org.bluez.AgentManager1"
+
+	// AgentPath is the D-Bus path at which RegisterAgent exports the agent.
+	AgentPath = dbus.ObjectPath("/org/ecc1/ble/agent")
+)
+
+// Agent capabilities, which determine the pairing methods that BlueZ uses.
+// See bluez/doc/agent-api.txt
+const (
+	DisplayOnly     = "DisplayOnly"
+	DisplayYesNo    = "DisplayYesNo"
+	KeyboardOnly    = "KeyboardOnly"
+	NoInputNoOutput = "NoInputNoOutput"
+	KeyboardDisplay = "KeyboardDisplay"
+)
+
+// Agent corresponds to the org.bluez.Agent1 interface,
+// which BlueZ calls to obtain or display credentials during pairing.
+// See bluez/doc/agent-api.txt
+//
+// Methods that return an error reject the request;
+// returning an error matching ErrCanceled cancels it instead.
+//
+// Release is called when BlueZ unregisters the agent.
+// Cancel is called when BlueZ cancels a request that is in progress.
+type Agent interface {
+	Release()
+	RequestPinCode(device Device) (string, error)
+	DisplayPinCode(device Device, pincode string) error
+	RequestPasskey(device Device) (uint32, error)
+	DisplayPasskey(device Device, passkey uint32, entered uint16) error
+	RequestConfirmation(device Device, passkey uint32) error
+	RequestAuthorization(device Device) error
+	AuthorizeService(device Device, uuid string) error
+	Cancel()
+}
+
+// AutoAcceptAgent is an Agent that accepts every confirmation
+// and authorization request, as for Just Works pairing.
+// It rejects requests for a PIN code or passkey.
+type AutoAcceptAgent struct{}
+
+func (AutoAcceptAgent) Release() {}
+
+func (AutoAcceptAgent) RequestPinCode(device Device) (string, error) {
+	return "", ErrRejected
+}
+
+func (AutoAcceptAgent) DisplayPinCode(device Device, pincode string) error {
+	log.Printf("%s: PIN code %s", device.Name(), pincode)
+	return nil
+}
+
+func (AutoAcceptAgent) RequestPasskey(device Device) (uint32, error) {
+	return 0, ErrRejected
+}
+
+func (AutoAcceptAgent) DisplayPasskey(device Device, passkey uint32, entered uint16) error {
+	log.Printf("%s: passkey %06d (%d entered)", device.Name(), passkey, entered)
+	return nil
+}
+
+func (AutoAcceptAgent) RequestConfirmation(device Device, passkey uint32) error {
+	return nil
+}
+
+func (AutoAcceptAgent) RequestAuthorization(device Device) error {
+	return nil
+}
+
+func (AutoAcceptAgent) AuthorizeService(device Device, uuid string) error {
+	return nil
+}
+
+func (AutoAcceptAgent) Cancel() {}
+
+// FixedPasskeyAgent is an Agent that supplies a fixed passkey
+// (and the same value as a PIN code), and confirms only that passkey.
+// Other requests are accepted, as by AutoAcceptAgent.
+type FixedPasskeyAgent struct {
+	AutoAcceptAgent
+	Passkey uint32
+}
+
+func (a FixedPasskeyAgent) RequestPinCode(device Device) (string, error) {
+	return strconv.FormatUint(uint64(a.Passkey), 10), nil
+}
+
+func (a FixedPasskeyAgent) RequestPasskey(device Device) (uint32, error) {
+	return a.Passkey, nil
+}
+
+func (a FixedPasskeyAgent) RequestConfirmation(device Device, passkey uint32) error {
+	if passkey != a.Passkey {
+		return fmt.Errorf("%w: passkey %06d does not match", ErrRejected, passkey)
+	}
+	return nil
+}
+
+// RegisterAgent exports the agent at AgentPath and registers it
+// with BlueZ as the default agent, with the given capability.
+// The connection's Backend must be an Exporter.
+func (conn *Connection) RegisterAgent(agent Agent, capability string) error {
+	exporter, ok := conn.backend.(Exporter)
+	if !ok {
+		return fmt.Errorf("%w: backend cannot export an agent", ErrNotSupported)
+	}
+	err := exporter.Export(AgentPath, agentInterface, conn.agentMethods(agent))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	_, err = conn.backend.Call(ctx, "/org/bluez", dot(agentManagerInterface, "RegisterAgent"), AgentPath, capability)
+	if err == nil {
+		_, err = conn.backend.Call(ctx, "/org/bluez", dot(agentManagerInterface, "RequestDefaultAgent"), AgentPath)
+	}
+	if err != nil {
+		_ = exporter.Unexport(AgentPath, agentInterface)
+		return err
+	}
+	return nil
+}
+
+// UnregisterAgent unregisters the agent exported by RegisterAgent.
+func (conn *Connection) UnregisterAgent() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	_, err := conn.backend.Call(ctx, "/org/bluez", dot(agentManagerInterface, "UnregisterAgent"), AgentPath)
+	if exporter, ok := conn.backend.(Exporter); ok {
+		_ = exporter.Unexport(AgentPath, agentInterface)
+	}
+	return err
+}
+
+// agentMethods returns the D-Bus method table for the agent.
+func (conn *Connection) agentMethods(agent Agent) map[string]interface{} {
+	return map[string]interface{}{
+		"Release": func() *dbus.Error {
+			agent.Release()
+			return nil
+		},
+		"RequestPinCode": func(path dbus.ObjectPath) (string, *dbus.Error) {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return "", agentError(err)
+			}
+			pincode, err := agent.RequestPinCode(device)
+			return pincode, agentError(err)
+		},
+		"DisplayPinCode": func(path dbus.ObjectPath, pincode string) *dbus.Error {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return agentError(err)
+			}
+			return agentError(agent.DisplayPinCode(device, pincode))
+		},
+		"RequestPasskey": func(path dbus.ObjectPath) (uint32, *dbus.Error) {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return 0, agentError(err)
+			}
+			passkey, err := agent.RequestPasskey(device)
+			return passkey, agentError(err)
+		},
+		"DisplayPasskey": func(path dbus.ObjectPath, passkey uint32, entered uint16) *dbus.Error {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return agentError(err)
+			}
+			return agentError(agent.DisplayPasskey(device, passkey, entered))
+		},
+		"RequestConfirmation": func(path dbus.ObjectPath, passkey uint32) *dbus.Error {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return agentError(err)
+			}
+			return agentError(agent.RequestConfirmation(device, passkey))
+		},
+		"RequestAuthorization": func(path dbus.ObjectPath) *dbus.Error {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return agentError(err)
+			}
+			return agentError(agent.RequestAuthorization(device))
+		},
+		"AuthorizeService": func(path dbus.ObjectPath, uuid string) *dbus.Error {
+			device, err := conn.agentDevice(path)
+			if err != nil {
+				return agentError(err)
+			}
+			return agentError(agent.AuthorizeService(device, uuid))
+		},
+		"Cancel": func() *dbus.Error {
+			agent.Cancel()
+			return nil
+		},
+	}
+}
+
+// agentDevice returns the device with the given path,
+// updating the object cache if it is not already there.
+func (conn *Connection) agentDevice(path dbus.ObjectPath) (Device, error) {
+	find := func() (Device, error) {
+		return conn.matchDevice("", func(device *blob) bool {
+			return device.path == path
+		})
+	}
+	device, err := find()
+	if err == nil {
+		return device, nil
+	}
+	err = conn.Update()
+	if err != nil {
+		return nil, err
+	}
+	return find()
+}
+
+// agentError converts an error returned by an Agent to a BlueZ agent error.
+func agentError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	name := "org.bluez.Error.Rejected"
+	if errors.Is(err, ErrCanceled) {
+		name = "org.bluez.Error.Canceled"
+	}
+	return dbus.NewError(name, []interface{}{err.Error()})
+}
@@ -0,0 +1,481 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	gattManagerInterface = "org.bluez.GattManager1"
+
+	// ApplicationPath is the D-Bus path at which RegisterApplication
+	// exports the application.  A Connection can register one application
+	// at a time.
+	ApplicationPath = dbus.ObjectPath("/org/ecc1/ble/app")
+)
+
+// A ReadFunc returns the value of a local characteristic or descriptor,
+// starting at the given offset.
+type ReadFunc func(offset uint16) ([]byte, error)
+
+// A WriteFunc handles a write to a local characteristic or descriptor.
+type WriteFunc func(value []byte, opts WriteOptions) error
+
+// A LocalService is a GATT service provided by this program,
+// acting as a peripheral.  See RegisterApplication.
+type LocalService struct {
+	UUID            string
+	Secondary       bool
+	Characteristics []*LocalCharacteristic
+}
+
+// A LocalCharacteristic is a characteristic of a LocalService.
+//
+// Value is the initial value.  Reads return the current value
+// unless OnRead is set, and writes replace it after OnWrite
+// (if set) accepts them.  Errors returned by OnRead and OnWrite
+// are sent to the client; use the sentinel values such as
+// ErrNotPermitted to choose the BlueZ error.
+type LocalCharacteristic struct {
+	UUID        string
+	Flags       GattFlags
+	Value       []byte
+	OnRead      ReadFunc
+	OnWrite     WriteFunc
+	Descriptors []*LocalDescriptor
+
+	localAttribute
+}
+
+// A LocalDescriptor is a descriptor of a LocalCharacteristic.
+// Its fields are used in the same way as those of LocalCharacteristic.
+type LocalDescriptor struct {
+	UUID    string
+	Flags   GattFlags
+	Value   []byte
+	OnRead  ReadFunc
+	OnWrite WriteFunc
+
+	localAttribute
+}
+
+// localAttribute holds the state of an exported characteristic or descriptor.
+type localAttribute struct {
+	mu        sync.Mutex
+	app       *Application
+	path      dbus.ObjectPath
+	value     []byte
+	updated   bool
+	notifying bool
+}
+
+// Path returns the D-Bus path of an exported characteristic or descriptor.
+func (attr *localAttribute) Path() dbus.ObjectPath {
+	attr.mu.Lock()
+	defer attr.mu.Unlock()
+	return attr.path
+}
+
+// Current returns the current value of an exported characteristic or descriptor.
+// Before registration, it returns the value most recently passed to Update, if any.
+func (attr *localAttribute) Current() []byte {
+	attr.mu.Lock()
+	defer attr.mu.Unlock()
+	return append([]byte(nil), attr.value...)
+}
+
+// setValue replaces the value of the attribute.
+// The caller must hold attr.mu.
+func (attr *localAttribute) setValue(value []byte) {
+	attr.value = append([]byte(nil), value...)
+	attr.updated = true
+}
+
+// bind associates the attribute with an application being registered.
+// The initial value is used unless Update has already been called.
+func (attr *localAttribute) bind(app *Application, path dbus.ObjectPath, initial []byte) {
+	attr.mu.Lock()
+	defer attr.mu.Unlock()
+	attr.app = app
+	attr.path = path
+	attr.notifying = false
+	if !attr.updated {
+		attr.value = append([]byte(nil), initial...)
+	}
+}
+
+// unbind detaches the attribute from its application.
+func (attr *localAttribute) unbind() {
+	attr.mu.Lock()
+	defer attr.mu.Unlock()
+	attr.app = nil
+	attr.notifying = false
+}
+
+// Notifying returns whether a client has enabled notifications.
+func (char *LocalCharacteristic) Notifying() bool {
+	char.mu.Lock()
+	defer char.mu.Unlock()
+	return char.notifying
+}
+
+// Update sets the characteristic's value and, if a client
+// has enabled notifications, sends it to the client.
+// Before the characteristic is registered, Update only stores the value.
+func (char *LocalCharacteristic) Update(value []byte) error {
+	char.mu.Lock()
+	char.setValue(value)
+	app := char.app
+	path := char.path
+	notifying := char.notifying
+	char.mu.Unlock()
+	if app == nil || !notifying {
+		return nil
+	}
+	return app.emitChanged(path, characteristicInterface, "Value", value)
+}
+
+// An Application is a set of local GATT services
+// registered with BlueZ by RegisterApplication.
+type Application struct {
+	adapter  *blob
+	services []*LocalService
+	objects  map[dbus.ObjectPath]Object
+}
+
+// RegisterApplication exports the given services at ApplicationPath
+// and registers them with BlueZ, which then provides them to clients
+// when the adapter acts as a peripheral.
+// The connection's Backend must be an Exporter,
+// and an Emitter if notifications are to be sent.
+// Since the application is exported at a fixed path, RegisterApplication
+// returns ErrAlreadyExists if the connection has already registered one,
+// on this or another adapter.
+func (adapter *blob) RegisterApplication(services ...*LocalService) (*Application, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.RegisterApplicationContext(ctx, services...)
+}
+
+// RegisterApplicationContext performs RegisterApplication, using the given context.
+func (adapter *blob) RegisterApplicationContext(ctx context.Context, services ...*LocalService) (*Application, error) {
+	exporter, ok := adapter.conn.backend.(Exporter)
+	if !ok {
+		return nil, fmt.Errorf("%w: backend cannot export an application", ErrNotSupported)
+	}
+	conn := adapter.conn
+	conn.appMu.Lock()
+	defer conn.appMu.Unlock()
+	if conn.application != nil {
+		return nil, fmt.Errorf("%w: application registered on %s", ErrAlreadyExists, conn.application.adapter.Name())
+	}
+	app := &Application{
+		adapter:  adapter,
+		services: services,
+		objects:  make(map[dbus.ObjectPath]Object),
+	}
+	err := app.build()
+	if err != nil {
+		return nil, err
+	}
+	err = app.export(exporter)
+	if err == nil {
+		log.Printf("%s: registering application with %d services", adapter.Name(), len(services))
+		_, err = conn.backend.Call(ctx, adapter.path, dot(gattManagerInterface, "RegisterApplication"), ApplicationPath, Properties{})
+	}
+	if err != nil {
+		app.unexport(exporter)
+		return nil, err
+	}
+	conn.application = app
+	return app, nil
+}
+
+// Unregister unregisters the application and removes its objects.
+func (app *Application) Unregister() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return app.UnregisterContext(ctx)
+}
+
+// UnregisterContext performs Unregister, using the given context.
+func (app *Application) UnregisterContext(ctx context.Context) error {
+	conn := app.adapter.conn
+	conn.appMu.Lock()
+	defer conn.appMu.Unlock()
+	if conn.application != app {
+		return fmt.Errorf("%w: application is not registered", ErrDoesNotExist)
+	}
+	_, err := conn.backend.Call(ctx, app.adapter.path, dot(gattManagerInterface, "UnregisterApplication"), ApplicationPath)
+	if exporter, ok := conn.backend.(Exporter); ok {
+		app.unexport(exporter)
+	}
+	conn.application = nil
+	return err
+}
+
+// build assigns paths to the application's objects and computes their properties.
+func (app *Application) build() error {
+	for i, svc := range app.services {
+		if !ValidUUID(svc.UUID) {
+			return fmt.Errorf("%w: invalid service UUID %q", ErrInvalidArguments, svc.UUID)
+		}
+		svcPath := dbus.ObjectPath(fmt.Sprintf("%s/service%d", ApplicationPath, i))
+		app.objects[svcPath] = Object{serviceInterface: {
+			"UUID":    dbus.MakeVariant(LongUUID(svc.UUID)),
+			"Primary": dbus.MakeVariant(!svc.Secondary),
+		}}
+		for j, char := range svc.Characteristics {
+			if !ValidUUID(char.UUID) {
+				return fmt.Errorf("%w: invalid characteristic UUID %q", ErrInvalidArguments, char.UUID)
+			}
+			charPath := dbus.ObjectPath(fmt.Sprintf("%s/char%d", svcPath, j))
+			char.bind(app, charPath, char.Value)
+			app.objects[charPath] = Object{characteristicInterface: {
+				"UUID":    dbus.MakeVariant(LongUUID(char.UUID)),
+				"Service": dbus.MakeVariant(svcPath),
+				"Flags":   dbus.MakeVariant(char.Flags.names()),
+			}}
+			for k, desc := range char.Descriptors {
+				if !ValidUUID(desc.UUID) {
+					return fmt.Errorf("%w: invalid descriptor UUID %q", ErrInvalidArguments, desc.UUID)
+				}
+				descPath := dbus.ObjectPath(fmt.Sprintf("%s/desc%d", charPath, k))
+				desc.bind(app, descPath, desc.Value)
+				app.objects[descPath] = Object{descriptorInterface: {
+					"UUID":           dbus.MakeVariant(LongUUID(desc.UUID)),
+					"Characteristic": dbus.MakeVariant(charPath),
+					"Flags":          dbus.MakeVariant(desc.Flags.names()),
+				}}
+			}
+		}
+	}
+	return nil
+}
+
+// export exports the application's object manager and objects.
+func (app *Application) export(exporter Exporter) error {
+	err := exporter.Export(ApplicationPath, objectManager, map[string]interface{}{
+		"GetManagedObjects": func() (map[dbus.ObjectPath]Object, *dbus.Error) {
+			objects := make(map[dbus.ObjectPath]Object, len(app.objects))
+			for path := range app.objects {
+				objects[path] = app.properties(path)
+			}
+			return objects, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for path := range app.objects {
+		err = exporter.Export(path, propertiesInterface, app.propertiesMethods(path))
+		if err != nil {
+			return err
+		}
+	}
+	for _, svc := range app.services {
+		for _, char := range svc.Characteristics {
+			err = exporter.Export(char.path, characteristicInterface, app.characteristicMethods(char))
+			if err != nil {
+				return err
+			}
+			for _, desc := range char.Descriptors {
+				err = exporter.Export(desc.path, descriptorInterface, app.attributeMethods(&desc.localAttribute, desc.OnRead, desc.OnWrite))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// unexport removes the application's objects
+// and detaches its attributes from it.
+func (app *Application) unexport(exporter Exporter) {
+	for _, svc := range app.services {
+		for _, char := range svc.Characteristics {
+			char.unbind()
+			for _, desc := range char.Descriptors {
+				desc.unbind()
+			}
+		}
+	}
+	_ = exporter.Unexport(ApplicationPath, objectManager)
+	for path, dict := range app.objects {
+		for iface := range dict {
+			_ = exporter.Unexport(path, iface)
+		}
+		_ = exporter.Unexport(path, propertiesInterface)
+	}
+}
+
+// properties returns the current properties of the object with the given path.
+func (app *Application) properties(path dbus.ObjectPath) Object {
+	attr := app.attribute(path)
+	dict := make(Object)
+	for iface, props := range app.objects[path] {
+		p := make(Properties, len(props)+2)
+		for k, v := range props {
+			p[k] = v
+		}
+		if attr != nil {
+			attr.mu.Lock()
+			p["Value"] = dbus.MakeVariant(append([]byte(nil), attr.value...))
+			if iface == characteristicInterface {
+				p["Notifying"] = dbus.MakeVariant(attr.notifying)
+			}
+			attr.mu.Unlock()
+		}
+		dict[iface] = p
+	}
+	return dict
+}
+
+// attribute returns the state of the characteristic or descriptor
+// with the given path, or nil if it is a service.
+func (app *Application) attribute(path dbus.ObjectPath) *localAttribute {
+	for _, svc := range app.services {
+		for _, char := range svc.Characteristics {
+			if char.Path() == path {
+				return &char.localAttribute
+			}
+			for _, desc := range char.Descriptors {
+				if desc.Path() == path {
+					return &desc.localAttribute
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-properties
+func (app *Application) propertiesMethods(path dbus.ObjectPath) map[string]interface{} {
+	return map[string]interface{}{
+		"Get": func(iface string, name string) (dbus.Variant, *dbus.Error) {
+			v, ok := app.properties(path)[iface][name]
+			if !ok {
+				return v, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"No such property " + name})
+			}
+			return v, nil
+		},
+		"GetAll": func(iface string) (Properties, *dbus.Error) {
+			props, ok := app.properties(path)[iface]
+			if !ok {
+				return nil, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"No such interface " + iface})
+			}
+			return props, nil
+		},
+		"Set": func(iface string, name string, value dbus.Variant) *dbus.Error {
+			return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []interface{}{"Property " + name + " is read-only"})
+		},
+	}
+}
+
+// characteristicMethods returns the D-Bus method table for a local characteristic.
+func (app *Application) characteristicMethods(char *LocalCharacteristic) map[string]interface{} {
+	methods := app.attributeMethods(&char.localAttribute, char.OnRead, char.OnWrite)
+	methods["StartNotify"] = func() *dbus.Error {
+		return app.setNotifying(char, true)
+	}
+	methods["StopNotify"] = func() *dbus.Error {
+		return app.setNotifying(char, false)
+	}
+	return methods
+}
+
+func (app *Application) setNotifying(char *LocalCharacteristic, notifying bool) *dbus.Error {
+	if notifying && char.Flags&notifyFlags == 0 {
+		return gattError(ErrNotSupported)
+	}
+	char.mu.Lock()
+	changed := char.notifying != notifying
+	char.notifying = notifying
+	char.mu.Unlock()
+	if changed {
+		_ = app.emitChanged(char.path, characteristicInterface, "Notifying", notifying)
+	}
+	return nil
+}
+
+// attributeMethods returns the ReadValue and WriteValue methods
+// for a local characteristic or descriptor.
+func (app *Application) attributeMethods(attr *localAttribute, onRead ReadFunc, onWrite WriteFunc) map[string]interface{} {
+	return map[string]interface{}{
+		"ReadValue": func(options Properties) ([]byte, *dbus.Error) {
+			offset, _ := options["offset"].Value().(uint16)
+			if onRead != nil {
+				value, err := onRead(offset)
+				return value, gattError(err)
+			}
+			attr.mu.Lock()
+			defer attr.mu.Unlock()
+			if int(offset) > len(attr.value) {
+				return nil, gattError(ErrInvalidOffset)
+			}
+			return append([]byte(nil), attr.value[offset:]...), nil
+		},
+		"WriteValue": func(value []byte, options Properties) *dbus.Error {
+			opts := parseWriteOptions(options)
+			if onWrite != nil {
+				err := onWrite(value, opts)
+				if err != nil || opts.PrepareAuthorize {
+					return gattError(err)
+				}
+			}
+			attr.mu.Lock()
+			defer attr.mu.Unlock()
+			if int(opts.Offset) > len(attr.value) {
+				return gattError(ErrInvalidOffset)
+			}
+			attr.setValue(append(attr.value[:opts.Offset:opts.Offset], value...))
+			return nil
+		},
+	}
+}
+
+// emitChanged emits a PropertiesChanged signal for a single property.
+func (app *Application) emitChanged(path dbus.ObjectPath, iface string, name string, value interface{}) error {
+	emitter, ok := app.adapter.conn.backend.(Emitter)
+	if !ok {
+		return fmt.Errorf("%w: backend cannot emit signals", ErrNotSupported)
+	}
+	return emitter.Emit(path, propertiesChanged, iface, Properties{name: dbus.MakeVariant(value)}, []string{})
+}
+
+// parseWriteOptions converts the options of a WriteValue call.
+func parseWriteOptions(options Properties) WriteOptions {
+	var opts WriteOptions
+	t, _ := options["type"].Value().(string)
+	for _, wt := range []WriteType{WriteCommand, WriteRequest, WriteReliable} {
+		if t == wt.String() {
+			opts.Type = wt
+		}
+	}
+	opts.Offset, _ = options["offset"].Value().(uint16)
+	opts.PrepareAuthorize, _ = options["prepare-authorize"].Value().(bool)
+	return opts
+}
+
+// gattError converts an error returned by a ReadFunc or WriteFunc
+// to the corresponding BlueZ error, or org.bluez.Error.Failed.
+func gattError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	name := "org.bluez.Error.Failed"
+	for n, e := range errorNames {
+		if strings.HasPrefix(n, "org.bluez.Error.") && errors.Is(err, e) {
+			name = n
+			break
+		}
+	}
+	return dbus.NewError(name, []interface{}{err.Error()})
+}
@@ -0,0 +1,193 @@
+package ble
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+// Backend is the interface between a Connection and the Bluetooth stack.
+// The default implementation talks to BlueZ over D-Bus;
+// others can be used for mocking, recording and replaying,
+// or alternative transports.
+//
+// ManagedObjects returns all objects and their properties,
+// as the org.freedesktop.DBus.ObjectManager.GetManagedObjects method does.
+//
+// Call calls a method, such as "org.bluez.Device1.Connect",
+// on the object with the given path and returns the results.
+// It should return an error satisfying errors.Is(err, ErrTimeout)
+// if the context's deadline expires.
+//
+// Signals returns a channel on which signals are delivered,
+// in the order they arrive.  It is called once, by OpenBackend,
+// and the channel must be closed when the backend is closed.
+//
+// AddMatch and RemoveMatch add and remove D-Bus match rules
+// for the signals to be delivered, using the given context
+// in the same way as Call.
+type Backend interface {
+	ManagedObjects(ctx context.Context) (map[dbus.ObjectPath]Object, error)
+	Call(ctx context.Context, path dbus.ObjectPath, method string, args ...interface{}) ([]interface{}, error)
+	Signals() <-chan *dbus.Signal
+
+	AddMatch(ctx context.Context, rule string) error
+	RemoveMatch(ctx context.Context, rule string) error
+
+	Close() error
+}
+
+// An Exporter is a Backend that can also export objects,
+// as needed to implement an Agent or a GATT application.
+//
+// Export exports the given methods of the interface at the given path.
+// Each method must be a function whose last result is a *dbus.Error.
+//
+// Unexport removes the interface exported at the given path.
+type Exporter interface {
+	Export(path dbus.ObjectPath, iface string, methods map[string]interface{}) error
+	Unexport(path dbus.ObjectPath, iface string) error
+}
+
+// An Emitter is a Backend that can also emit signals,
+// as needed to send notifications from a local characteristic.
+type Emitter interface {
+	Emit(path dbus.ObjectPath, name string, values ...interface{}) error
+}
+
+// dbusBackend implements Backend using a private D-Bus connection to BlueZ.
+type dbusBackend struct {
+	bus     *dbus.Conn
+	signals chan *dbus.Signal
+
+	// mu protects closed, so that no call is started
+	// once the bus connection is being closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// SystemBackend returns a Backend that uses a private connection
+// to the system D-Bus.  A private connection is used so that signals,
+// including notifications, are delivered in the order they arrive.
+func SystemBackend() (Backend, error) {
+	queue := newSignalQueue()
+	bus, err := dbus.SystemBusPrivateHandler(dbus.NewDefaultHandler(), queue)
+	if err != nil {
+		return nil, err
+	}
+	return newDBusBackend(bus, queue)
+}
+
+// ConnBackend returns a Backend that uses D-Bus over an already established
+// transport, such as the one provided by the fake BlueZ service
+// in the bletest package.
+func ConnBackend(transport io.ReadWriteCloser) (Backend, error) {
+	queue := newSignalQueue()
+	bus, err := dbus.NewConnHandler(transport, dbus.NewDefaultHandler(), queue)
+	if err != nil {
+		return nil, err
+	}
+	return newDBusBackend(bus, queue)
+}
+
+// newDBusBackend authenticates a private D-Bus connection
+// using the given signal queue.
+func newDBusBackend(bus *dbus.Conn, queue *signalQueue) (*dbusBackend, error) {
+	err := bus.Auth(nil)
+	if err == nil {
+		err = bus.Hello()
+	}
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+	b := &dbusBackend{
+		bus:     bus,
+		signals: make(chan *dbus.Signal),
+	}
+	go queue.run(b.signals)
+	return b, nil
+}
+
+// See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-objectmanager
+func (b *dbusBackend) ManagedObjects(ctx context.Context) (map[dbus.ObjectPath]Object, error) {
+	var objects map[dbus.ObjectPath]Object
+	body, err := b.Call(ctx, "/", dot(objectManager, "GetManagedObjects"))
+	if err == nil {
+		err = dbus.Store(body, &objects)
+	}
+	return objects, err
+}
+
+func (b *dbusBackend) Call(ctx context.Context, path dbus.ObjectPath, method string, args ...interface{}) ([]interface{}, error) {
+	call := b.goCall(ctx, b.bus.Object("org.bluez", path), method, args...)
+	return call.Body, call.Err
+}
+
+func (b *dbusBackend) Signals() <-chan *dbus.Signal {
+	return b.signals
+}
+
+func (b *dbusBackend) AddMatch(ctx context.Context, rule string) error {
+	return b.goCall(ctx, b.bus.BusObject(), "org.freedesktop.DBus.AddMatch", rule).Err
+}
+
+func (b *dbusBackend) RemoveMatch(ctx context.Context, rule string) error {
+	return b.goCall(ctx, b.bus.BusObject(), "org.freedesktop.DBus.RemoveMatch", rule).Err
+}
+
+func (b *dbusBackend) Export(path dbus.ObjectPath, iface string, methods map[string]interface{}) error {
+	return b.bus.ExportMethodTable(methods, path, iface)
+}
+
+func (b *dbusBackend) Unexport(path dbus.ObjectPath, iface string) error {
+	return b.bus.Export(nil, path, iface)
+}
+
+func (b *dbusBackend) Emit(path dbus.ObjectPath, name string, values ...interface{}) error {
+	return b.bus.Emit(path, name, values...)
+}
+
+func (b *dbusBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return b.bus.Close()
+}
+
+// errBackendClosed is returned for calls made after the backend is closed.
+var errBackendClosed = fmt.Errorf("%w: connection closed", ErrNotReady)
+
+// goCall starts a method call and waits for it using waitCall.
+// No call is made if the context is already done
+// or the backend has been closed.
+func (b *dbusBackend) goCall(ctx context.Context, obj dbus.BusObject, method string, args ...interface{}) *dbus.Call {
+	if ctx.Err() != nil {
+		return &dbus.Call{Err: contextError(ctx)}
+	}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return &dbus.Call{Err: errBackendClosed}
+	}
+	c := obj.Go(method, 0, nil, args...)
+	b.mu.RUnlock()
+	return waitCall(ctx, c)
+}
+
+// waitCall waits for a pending call to complete or for the context to be done.
+// In the latter case, a new Call is returned so the pending one
+// can still be completed safely by the D-Bus connection.
+// D-Bus errors are converted to Error values; see mapError.
+func waitCall(ctx context.Context, c *dbus.Call) *dbus.Call {
+	select {
+	case c = <-c.Done:
+	case <-ctx.Done():
+		return &dbus.Call{Err: contextError(ctx)}
+	}
+	c.Err = mapError(c.Err)
+	return c
+}
@@ -0,0 +1,191 @@
+package ble_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+
+	"github.com/ecc1/ble"
+)
+
+// mockBackend is a minimal Backend that records method calls.
+type mockBackend struct {
+	objects map[dbus.ObjectPath]ble.Object
+	signals chan *dbus.Signal
+
+	mu      sync.Mutex
+	calls   []string
+	results map[string][]interface{}
+	once    sync.Once
+
+	// duringUpdate, if set, is called while ManagedObjects is in progress.
+	duringUpdate func()
+}
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{
+		objects: map[dbus.ObjectPath]ble.Object{
+			"/org/bluez/hci0": {
+				"org.bluez.Adapter1": {
+					"Address": dbus.MakeVariant("00:11:22:33:44:55"),
+					"Name":    dbus.MakeVariant("mock"),
+				},
+			},
+		},
+		signals: make(chan *dbus.Signal),
+	}
+}
+
+// ManagedObjects returns a copy of the objects, as a new reply would.
+func (b *mockBackend) ManagedObjects(ctx context.Context) (map[dbus.ObjectPath]ble.Object, error) {
+	if b.duringUpdate != nil {
+		b.duringUpdate()
+	}
+	objects := make(map[dbus.ObjectPath]ble.Object, len(b.objects))
+	for path, dict := range b.objects {
+		objects[path] = make(ble.Object, len(dict))
+		for iface, props := range dict {
+			objects[path][iface] = make(ble.Properties, len(props))
+			for k, v := range props {
+				objects[path][iface][k] = v
+			}
+		}
+	}
+	return objects, nil
+}
+
+func (b *mockBackend) Call(ctx context.Context, path dbus.ObjectPath, method string, args ...interface{}) ([]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, string(path)+" "+method)
+	return b.results[method], nil
+}
+
+func (b *mockBackend) Signals() <-chan *dbus.Signal {
+	return b.signals
+}
+
+func (b *mockBackend) AddMatch(ctx context.Context, rule string) error    { return nil }
+func (b *mockBackend) RemoveMatch(ctx context.Context, rule string) error { return nil }
+
+func (b *mockBackend) Close() error {
+	b.once.Do(func() { close(b.signals) })
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	backend := newMockBackend()
+	conn, err := ble.OpenBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	adapter, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.Name() != "mock" {
+		t.Errorf("adapter name = %q", adapter.Name())
+	}
+	err = adapter.StartDiscovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/org/bluez/hci0 org.bluez.Adapter1.StartDiscovery"
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if len(backend.calls) != 1 || backend.calls[0] != want {
+		t.Errorf("calls = %v, want [%s]", backend.calls, want)
+	}
+}
+
+func TestUpdateWhileWatching(t *testing.T) {
+	backend := newMockBackend()
+	conn, err := ble.OpenBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = conn.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deliver a change that the (stale) reply does not include.
+	backend.duringUpdate = func() {
+		backend.signals <- &dbus.Signal{
+			Path: "/org/bluez/hci0",
+			Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+			Body: []interface{}{
+				"org.bluez.Adapter1",
+				map[string]dbus.Variant{"Alias": dbus.MakeVariant("changed")},
+				[]string{},
+			},
+		}
+		deadline := time.Now().Add(time.Second)
+		for adapter.Alias() != "changed" && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	err = conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.Alias() != "changed" {
+		t.Errorf("Update overwrote signaled alias with %q", adapter.Alias())
+	}
+}
+
+func TestAcquireNotify(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := os.NewFile(uintptr(fds[1]), "peer")
+	defer peer.Close()
+	backend := newMockBackend()
+	backend.objects["/org/bluez/hci0/dev_AA_BB_CC_DD_EE_01/service0001/char0002"] = ble.Object{
+		"org.bluez.GattCharacteristic1": {
+			"UUID":  dbus.MakeVariant("00002a37-0000-1000-8000-00805f9b34fb"),
+			"Flags": dbus.MakeVariant([]string{"notify"}),
+		},
+	}
+	backend.results = map[string][]interface{}{
+		"org.bluez.GattCharacteristic1.AcquireNotify": {dbus.UnixFD(fds[0]), uint16(185)},
+	}
+	conn, err := ble.OpenBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	char, err := conn.GetCharacteristic("00002a37-0000-1000-8000-00805f9b34fb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, mtu, err := char.AcquireNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if mtu != 185 || char.MTU() != 185 {
+		t.Errorf("MTU = %d, %d, want 185", mtu, char.MTU())
+	}
+	_, err = peer.Write([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, mtu)
+	n, err := r.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], []byte{1, 2, 3}) {
+		t.Errorf("Read returned %v, %v", buf[:n], err)
+	}
+}
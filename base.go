@@ -8,15 +8,20 @@ It is similar to github.com/adafruit/Adafruit_Python_BluefruitLE
 package ble
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/godbus/dbus"
 )
 
 const (
-	objectManager = "org.freedesktop.DBus.ObjectManager"
+	objectManager       = "org.freedesktop.DBus.ObjectManager"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
 
 	callTimeout = 5 * time.Second
 )
@@ -33,50 +38,162 @@ type (
 	// as a map from property names to values (D-Bus variants).
 	Properties = map[string]dbus.Variant
 
-	// Connection represents a D-Bus connection.
+	// Connection represents a connection to BlueZ through a Backend.
+	//
+	// A Connection may be used concurrently from multiple goroutines.
 	Connection struct {
-		bus     *dbus.Conn
-		objects map[dbus.ObjectPath]Object
+		// signalsDropped is accessed atomically,
+		// and is first to ensure 64-bit alignment.
+		signalsDropped uint64
+
+		backend Backend
+
+		// mu protects the object cache, including the properties
+		// of objects that have been returned from it.
+		mu       sync.RWMutex
+		objects  map[dbus.ObjectPath]Object
+		watching bool
+		scanning map[dbus.ObjectPath]bool
+
+		// updating counts the Updates in progress, and replay holds
+		// the signals applied by Watch since the first one began.
+		updating int
+		replay   []*dbus.Signal
+
+		sigMu       sync.Mutex
+		subscribers map[*signalSubscriber]struct{}
+		sigClosed   bool
+
+		notifyMu     sync.Mutex
+		notifyQueues map[dbus.ObjectPath]*notifyQueue
+
+		appMu       sync.Mutex
+		application *Application
 	}
 
 	// Address represents a MAC address.
 	Address string
 )
 
-// Open opens a connection to the system D-Bus
+// Open opens a connection to BlueZ over the system D-Bus.
 func Open() (*Connection, error) {
-	bus, err := dbus.SystemBus()
+	backend, err := SystemBackend()
+	if err != nil {
+		return nil, err
+	}
+	return OpenBackend(backend)
+}
+
+// OpenConn opens a connection to D-Bus over an already established transport,
+// such as the one provided by the fake BlueZ service in the bletest package.
+func OpenConn(transport io.ReadWriteCloser) (*Connection, error) {
+	backend, err := ConnBackend(transport)
 	if err != nil {
 		return nil, err
 	}
-	conn := Connection{bus: bus}
-	err = conn.Update()
+	return OpenBackend(backend)
+}
+
+// OpenBackend opens a connection that uses the given backend
+// and loads the object cache.
+func OpenBackend(backend Backend) (*Connection, error) {
+	conn := &Connection{
+		backend:      backend,
+		subscribers:  make(map[*signalSubscriber]struct{}),
+		notifyQueues: make(map[dbus.ObjectPath]*notifyQueue),
+	}
+	go conn.signalLoop(backend.Signals())
+	err := conn.Update()
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
-	return &conn, nil
+	return conn, nil
 }
 
-// Close closes the D-Bus connection.
+// Close stops delivering notifications and closes the connection's backend.
+// Notifications already queued for handlers are dropped.
 func (conn *Connection) Close() {
-	conn.bus.Close()
+	conn.stopNotifyQueues()
+	_ = conn.backend.Close()
 }
 
 // Update gets all objects and properties.
+// The properties of objects already in the cache are updated in place,
+// so objects previously returned from the cache see the new values.
 // See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-objectmanager
 func (conn *Connection) Update() error {
-	obj := conn.bus.Object("org.bluez", "/")
-	call := obj.Call(dot(objectManager, "GetManagedObjects"), 0)
-	return call.Store(&conn.objects)
+	ctx, cancel := callContext()
+	defer cancel()
+	return conn.UpdateContext(ctx)
+}
+
+// UpdateContext is like Update but uses the given context
+// instead of the default call timeout.
+// While the cache is being watched, signals applied during the call
+// are applied again to the result, so it does not overwrite newer values.
+func (conn *Connection) UpdateContext(ctx context.Context) error {
+	conn.mu.Lock()
+	conn.updating++
+	conn.mu.Unlock()
+	objects, err := conn.backend.ManagedObjects(ctx)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	replay := conn.replay
+	conn.updating--
+	if conn.updating == 0 {
+		conn.replay = nil
+	}
+	if err != nil {
+		return err
+	}
+	for path, dict := range objects {
+		old := conn.objects[path]
+		for iface, props := range dict {
+			if oldProps := old[iface]; oldProps != nil {
+				replaceProperties(oldProps, props)
+				dict[iface] = oldProps
+			}
+		}
+	}
+	conn.objects = objects
+	for _, s := range replay {
+		conn.applySignalLocked(s)
+	}
+	return nil
+}
+
+// copyProperties returns a shallow copy of props.
+func copyProperties(props Properties) Properties {
+	c := make(Properties, len(props))
+	for key, val := range props {
+		c[key] = val
+	}
+	return c
+}
+
+// replaceProperties replaces the contents of props with those of newProps.
+func replaceProperties(props Properties, newProps Properties) {
+	for key := range props {
+		if _, ok := newProps[key]; !ok {
+			delete(props, key)
+		}
+	}
+	for key, val := range newProps {
+		props[key] = val
+	}
 }
 
 // The iterObjects function applies a function of type objectProc to
 // each object in the cache.  It should return true if the iteration
 // should stop, false if it should continue.
+// The cache is locked during the iteration, so the function
+// must not call methods that access object properties.
 type objectProc func(dbus.ObjectPath, Object) bool
 
 func (conn *Connection) iterObjects(proc objectProc) {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
 	for path, dict := range conn.objects {
 		if proc(path, dict) {
 			return
@@ -101,12 +218,19 @@ func printObject(w io.Writer, path dbus.ObjectPath, dict Object) bool {
 }
 
 // BaseObject is the interface satisfied by bluez D-Bus objects.
+//
+// GetProperty gets the current value of a property from BlueZ,
+// and SetProperty sets it, using the org.freedesktop.DBus.Properties
+// interface.  Both update the object cache.
 type BaseObject interface {
 	Conn() *Connection
 	Path() dbus.ObjectPath
 	Interface() string
 	Name() string
 	Print(io.Writer)
+
+	GetProperty(ctx context.Context, name string) (dbus.Variant, error)
+	SetProperty(ctx context.Context, name string, value interface{}) error
 }
 
 type blob struct {
@@ -114,7 +238,6 @@ type blob struct {
 	path       dbus.ObjectPath
 	iface      string
 	properties Properties
-	object     dbus.BusObject
 }
 
 // Conn returns the object's D-Bus connection.
@@ -132,34 +255,89 @@ func (obj *blob) Interface() string {
 	return obj.iface
 }
 
+// property returns the value of the named property.
+// The connection's lock is held since the properties
+// may be updated concurrently.
+func (obj *blob) property(name string) dbus.Variant {
+	obj.conn.mu.RLock()
+	defer obj.conn.mu.RUnlock()
+	return obj.properties[name]
+}
+
 // Name returns the object's name.
 func (obj *blob) Name() string {
-	name, ok := obj.properties["Name"].Value().(string)
+	name, ok := obj.property("Name").Value().(string)
 	if !ok {
 		return string(obj.path)
 	}
 	return name
 }
 
-func (obj *blob) callv(method string, args ...interface{}) *dbus.Call {
-	c := obj.object.Go(dot(obj.iface, method), 0, nil, args...)
-	if c.Err == nil {
-		select {
-		case <-c.Done:
-		case <-time.After(callTimeout):
-			c.Err = fmt.Errorf("BLE call timeout")
-		}
+// GetProperty gets the current value of the named property.
+// See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-properties
+func (obj *blob) GetProperty(ctx context.Context, name string) (dbus.Variant, error) {
+	var value dbus.Variant
+	body, err := obj.conn.backend.Call(ctx, obj.path, dot(propertiesInterface, "Get"), obj.iface, name)
+	if err == nil {
+		err = dbus.Store(body, &value)
 	}
-	return c
+	if err != nil {
+		return value, err
+	}
+	obj.conn.changeProperties(obj.path, obj.iface, Properties{name: value}, nil)
+	return value, nil
+}
+
+// SetProperty sets the named property to the given value.
+func (obj *blob) SetProperty(ctx context.Context, name string, value interface{}) error {
+	v := dbus.MakeVariant(value)
+	_, err := obj.conn.backend.Call(ctx, obj.path, dot(propertiesInterface, "Set"), obj.iface, name, v)
+	if err != nil {
+		return err
+	}
+	obj.conn.changeProperties(obj.path, obj.iface, Properties{name: v}, nil)
+	return nil
+}
+
+// setProperty sets the named property using the default call timeout.
+func (obj *blob) setProperty(name string, value interface{}) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return obj.SetProperty(ctx, name, value)
+}
+
+// getProperty gets the named property using the default call timeout
+// and stores its value in the given pointer.
+func (obj *blob) getProperty(name string, ptr interface{}) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	value, err := obj.GetProperty(ctx, name)
+	if err != nil {
+		return err
+	}
+	return dbus.Store([]interface{}{value}, ptr)
+}
+
+// callContext returns a context that applies the default timeout
+// to the D-Bus calls made by methods without a Context variant.
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), callTimeout)
 }
 
-func (obj *blob) call(method string, args ...interface{}) error {
-	return obj.callv(method, args...).Err
+func (obj *blob) callvContext(ctx context.Context, method string, args ...interface{}) *dbus.Call {
+	body, err := obj.conn.backend.Call(ctx, obj.path, dot(obj.iface, method), args...)
+	return &dbus.Call{Body: body, Err: err}
+}
+
+func (obj *blob) callContext(ctx context.Context, method string, args ...interface{}) error {
+	return obj.callvContext(ctx, method, args...).Err
 }
 
 // Print prints the object.
 func (obj *blob) Print(w io.Writer) {
 	fmt.Fprintf(w, "%s [%s]\n", obj.path, obj.iface)
+	obj.conn.mu.RLock()
+	defer obj.conn.mu.RUnlock()
 	printProperties(w, "", obj.properties)
 }
 
@@ -190,35 +368,52 @@ func printProperties(w io.Writer, iface string, props Properties) {
 // The findObject function tests each object with functions of type predicate.
 type predicate func(*blob) bool
 
-// findObject finds an object satisfying the given predicate.
-// If returns an error if zero or more than one is found.
-func (conn *Connection) findObject(iface string, matching predicate) (*blob, error) {
-	var found []*blob
+// findObjects finds all objects with the given interface
+// satisfying the given predicate, in order of their paths.
+func (conn *Connection) findObjects(iface string, matching predicate) []*blob {
+	// Collect the candidates first, since the predicate
+	// cannot be applied while the cache is locked.
+	var candidates []*blob
 	conn.iterObjects(func(path dbus.ObjectPath, dict Object) bool {
 		props := dict[iface]
 		if props == nil {
 			return false
 		}
-		obj := &blob{
+		candidates = append(candidates, &blob{
 			conn:       conn,
 			path:       path,
 			iface:      iface,
 			properties: props,
-			object:     conn.bus.Object("org.bluez", path),
-		}
+		})
+		return false
+	})
+	var found []*blob
+	for _, obj := range candidates {
 		if matching(obj) {
 			found = append(found, obj)
 		}
-		return false
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].path < found[j].path
 	})
-	switch len(found) {
-	case 1:
-		return found[0], nil
-	case 0:
-		return nil, fmt.Errorf("cannot find %s", iface)
-	default:
-		return nil, fmt.Errorf("found %d instances of %s", len(found), iface)
+	return found
+}
+
+// findObject finds an object satisfying the given predicate.
+// If returns an error matching ErrNotFound or ErrAmbiguous
+// if zero or more than one is found.
+func (conn *Connection) findObject(iface string, matching predicate) (*blob, error) {
+	found := conn.findObjects(iface, matching)
+	if len(found) != 1 {
+		return nil, lookupError{iface: iface, count: len(found)}
 	}
+	return found[0], nil
+}
+
+// inTree checks whether path is a descendant of the given root,
+// or whether root is empty.
+func inTree(path dbus.ObjectPath, root dbus.ObjectPath) bool {
+	return root == "" || strings.HasPrefix(string(path), string(root)+"/")
 }
 
 func dot(a, b string) string {
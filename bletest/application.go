@@ -0,0 +1,185 @@
+package bletest
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+// Application is a GATT application registered by the client
+// with the org.bluez.GattManager1.RegisterApplication method.
+// Its methods call the client's objects, as a remote device would.
+type Application struct {
+	a    *Adapter
+	path dbus.ObjectPath
+
+	mu       sync.Mutex
+	objects  map[dbus.ObjectPath]map[string]Properties
+	notified map[dbus.ObjectPath][][]byte
+}
+
+func (a *Adapter) registerApplication(path dbus.ObjectPath, options Properties) *dbus.Error {
+	a.mu.Lock()
+	registered := a.app != nil
+	a.mu.Unlock()
+	if registered {
+		return bluezError("AlreadyExists", "Already Exists")
+	}
+	var objects map[dbus.ObjectPath]map[string]Properties
+	err := a.s.conn.Object(clientName, path).Call(objectManager+".GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		return bluezError("Failed", "Failed to read application: %v", err)
+	}
+	log.Printf("bletest: registering application %s with %d objects", path, len(objects))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.app = &Application{
+		a:        a,
+		path:     path,
+		objects:  objects,
+		notified: make(map[dbus.ObjectPath][][]byte),
+	}
+	return nil
+}
+
+func (a *Adapter) unregisterApplication(path dbus.ObjectPath) *dbus.Error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.app == nil || a.app.path != path {
+		return bluezError("DoesNotExist", "Does Not Exist")
+	}
+	a.app = nil
+	return nil
+}
+
+// Application returns the GATT application registered with the adapter,
+// or nil if there is none.
+func (a *Adapter) Application() *Application {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.app
+}
+
+// Path returns the application's D-Bus object path.
+func (app *Application) Path() dbus.ObjectPath {
+	return app.path
+}
+
+// Objects returns the application's objects, as they were
+// when the application was registered.
+func (app *Application) Objects() map[dbus.ObjectPath]map[string]Properties {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	objects := make(map[dbus.ObjectPath]map[string]Properties, len(app.objects))
+	for path, dict := range app.objects {
+		objects[path] = copyObject(dict)
+	}
+	return objects
+}
+
+// find returns the path and interface of the characteristic
+// or descriptor with the given UUID.
+func (app *Application) find(uuid string) (dbus.ObjectPath, string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	for path, dict := range app.objects {
+		for _, iface := range []string{characteristicInterface, descriptorInterface} {
+			if props, ok := dict[iface]; ok && props["UUID"].Value() == uuid {
+				return path, iface, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("bletest: application has no attribute with UUID %s", uuid)
+}
+
+// call calls a method of the characteristic or descriptor with the given UUID.
+func (app *Application) call(uuid string, method string, args ...interface{}) *dbus.Call {
+	path, iface, err := app.find(uuid)
+	if err != nil {
+		return &dbus.Call{Err: err}
+	}
+	return app.a.s.conn.Object(clientName, path).Call(iface+"."+method, 0, args...)
+}
+
+// ReadValue reads the value of the characteristic or descriptor
+// with the given UUID, starting at the given offset.
+func (app *Application) ReadValue(uuid string, offset uint16) ([]byte, error) {
+	options := Properties{}
+	if offset != 0 {
+		options["offset"] = dbus.MakeVariant(offset)
+	}
+	var value []byte
+	err := app.call(uuid, "ReadValue", options).Store(&value)
+	return value, err
+}
+
+// WriteValue writes a value to the characteristic or descriptor
+// with the given UUID, using the given options.
+func (app *Application) WriteValue(uuid string, value []byte, options Properties) error {
+	if options == nil {
+		options = Properties{}
+	}
+	return app.call(uuid, "WriteValue", value, options).Err
+}
+
+// StartNotify enables notifications from the characteristic with the given UUID.
+func (app *Application) StartNotify(uuid string) error {
+	return app.call(uuid, "StartNotify").Err
+}
+
+// StopNotify disables notifications from the characteristic with the given UUID.
+func (app *Application) StopNotify(uuid string) error {
+	return app.call(uuid, "StopNotify").Err
+}
+
+// Notified returns the values sent by the characteristic with the given UUID
+// while notifications were enabled, in order.
+func (app *Application) Notified(uuid string) [][]byte {
+	path, _, err := app.find(uuid)
+	if err != nil {
+		return nil
+	}
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return append([][]byte(nil), app.notified[path]...)
+}
+
+// receiveSignals records the values of characteristics
+// sent by the client in PropertiesChanged signals.
+func (s *Server) receiveSignals(signals <-chan *dbus.Signal) {
+	for sig := range signals {
+		if sig.Name != propertiesInterface+".PropertiesChanged" || len(sig.Body) < 2 {
+			continue
+		}
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		value, ok := changed["Value"].Value().([]byte)
+		if !ok {
+			continue
+		}
+		for _, app := range s.applications() {
+			if !strings.HasPrefix(string(sig.Path), string(app.path)+"/") {
+				continue
+			}
+			app.mu.Lock()
+			app.notified[sig.Path] = append(app.notified[sig.Path], value)
+			app.mu.Unlock()
+		}
+	}
+}
+
+// applications returns the applications registered with the server's adapters.
+func (s *Server) applications() []*Application {
+	s.mu.Lock()
+	adapters := append([]*Adapter(nil), s.adapters...)
+	s.mu.Unlock()
+	var apps []*Application
+	for _, a := range adapters {
+		if app := a.Application(); app != nil {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
@@ -0,0 +1,99 @@
+package bletest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	busGUID = "0123456789abcdef0123456789abcdef"
+)
+
+// A pipeBus connects two D-Bus peers in memory.
+// It plays the part of the message bus during authentication,
+// and then simply relays messages between the peers.
+type pipeBus struct {
+	server net.Conn
+	client net.Conn
+
+	// Ends used by the bus itself.
+	serverEnd net.Conn
+	clientEnd net.Conn
+
+	// attached is closed when the client has been authenticated.
+	attached chan struct{}
+}
+
+func newPipeBus() *pipeBus {
+	b := &pipeBus{attached: make(chan struct{})}
+	b.server, b.serverEnd = net.Pipe()
+	b.client, b.clientEnd = net.Pipe()
+	go b.run()
+	return b
+}
+
+func (b *pipeBus) run() {
+	server, err := authenticate(b.serverEnd)
+	if err != nil {
+		b.close()
+		return
+	}
+	client, err := authenticate(b.clientEnd)
+	if err != nil {
+		b.close()
+		return
+	}
+	close(b.attached)
+	go relay(b.clientEnd, server)
+	relay(b.serverEnd, client)
+	b.close()
+}
+
+func relay(dst io.Writer, src io.Reader) {
+	_, _ = io.Copy(dst, src)
+}
+
+func (b *pipeBus) close() {
+	b.serverEnd.Close()
+	b.clientEnd.Close()
+}
+
+// authenticate performs the server side of the D-Bus authentication protocol,
+// accepting the EXTERNAL mechanism without checking credentials.
+// It returns a reader for the messages that follow.
+// See https://dbus.freedesktop.org/doc/dbus-specification.html#auth-protocol
+func authenticate(conn net.Conn) (*bufio.Reader, error) {
+	r := bufio.NewReader(conn)
+	nul, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if nul != 0 {
+		return nil, fmt.Errorf("bletest: expected NUL byte, got %q", nul)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		var reply string
+		switch {
+		case line == "BEGIN":
+			return r, nil
+		case strings.HasPrefix(line, "AUTH EXTERNAL"):
+			reply = "OK " + busGUID
+		case strings.HasPrefix(line, "AUTH"):
+			reply = "REJECTED EXTERNAL"
+		default:
+			reply = "ERROR"
+		}
+		_, err = io.WriteString(conn, reply+"\r\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,715 @@
+package bletest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+// Peripheral describes a virtual peripheral device.
+type Peripheral struct {
+	Address     string
+	AddressType string // "public" if empty
+	Name        string
+	UUIDs       []string
+	RSSI        int16
+
+	// Properties contains additional org.bluez.Device1 properties,
+	// such as ManufacturerData or Appearance.
+	Properties map[string]interface{}
+
+	// Services are exported when the device is first connected.
+	Services []GattService
+
+	// Pairing is the pairing method: "" for Just Works,
+	// "passkey" to ask the client's agent for Passkey,
+	// or "confirm" to ask the agent to confirm Passkey.
+	Pairing string
+	Passkey uint32
+
+	// MTU is the ATT MTU reported by the characteristics' MTU property.
+	// If zero, the property is omitted.
+	MTU uint16
+}
+
+// GattService describes a GATT service of a virtual peripheral.
+type GattService struct {
+	UUID            string
+	Characteristics []GattCharacteristic
+}
+
+// GattCharacteristic describes a GATT characteristic of a virtual peripheral.
+type GattCharacteristic struct {
+	UUID        string
+	Flags       []string
+	Value       []byte
+	Descriptors []GattDescriptor
+}
+
+// GattDescriptor describes a GATT descriptor of a virtual peripheral.
+type GattDescriptor struct {
+	UUID  string
+	Flags []string
+	Value []byte
+}
+
+// Adapter is a virtual org.bluez.Adapter1 object.
+type Adapter struct {
+	s    *Server
+	path dbus.ObjectPath
+
+	mu          sync.Mutex
+	devices     map[string]*Device
+	advertising []*Device
+	filter      Properties
+	app         *Application
+}
+
+// AddAdapter adds an adapter with the given name (such as "hci0") and address.
+func (s *Server) AddAdapter(name string, address string) *Adapter {
+	a := &Adapter{
+		s:       s,
+		path:    dbus.ObjectPath("/org/bluez/" + name),
+		devices: make(map[string]*Device),
+	}
+	s.mu.Lock()
+	s.adapters = append(s.adapters, a)
+	s.mu.Unlock()
+	s.addObject(a.path, map[string]Properties{
+		adapterInterface: {
+			"Address":              dbus.MakeVariant(address),
+			"AddressType":          dbus.MakeVariant("public"),
+			"Name":                 dbus.MakeVariant("bletest"),
+			"Alias":                dbus.MakeVariant("bletest"),
+			"Class":                dbus.MakeVariant(uint32(0)),
+			"Powered":              dbus.MakeVariant(true),
+			"Discoverable":         dbus.MakeVariant(false),
+			"DiscoverableTimeout":  dbus.MakeVariant(uint32(180)),
+			"Pairable":             dbus.MakeVariant(false),
+			"PairableTimeout":      dbus.MakeVariant(uint32(0)),
+			"Discovering":          dbus.MakeVariant(false),
+			"UUIDs":                dbus.MakeVariant([]string{}),
+			"Modalias":             dbus.MakeVariant("usb:v1D6Bp0246d0535"),
+			"Roles":                dbus.MakeVariant([]string{"central", "peripheral"}),
+			"ExperimentalFeatures": dbus.MakeVariant([]string{}),
+		},
+	}, map[string]map[string]interface{}{
+		adapterInterface: {
+			"StartDiscovery":      a.startDiscovery,
+			"StopDiscovery":       a.stopDiscovery,
+			"SetDiscoveryFilter":  a.setDiscoveryFilter,
+			"GetDiscoveryFilters": a.getDiscoveryFilters,
+			"RemoveDevice":        a.removeDevice,
+		},
+		gattManagerInterface: {
+			"RegisterApplication":   a.registerApplication,
+			"UnregisterApplication": a.unregisterApplication,
+		},
+	})
+	return a
+}
+
+// Path returns the adapter's D-Bus object path.
+func (a *Adapter) Path() dbus.ObjectPath {
+	return a.path
+}
+
+// Device returns the device with the given address that has been added to
+// or discovered by the adapter, or nil if there is none.
+func (a *Adapter) Device(address string) *Device {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.devices[strings.ToUpper(address)]
+}
+
+// Discovering returns whether the adapter is in discovery mode.
+func (a *Adapter) Discovering() bool {
+	discovering, _ := a.s.Property(a.path, adapterInterface, "Discovering").Value().(bool)
+	return discovering
+}
+
+// AddDevice adds a known device (one that has been discovered previously)
+// to the adapter.
+func (a *Adapter) AddDevice(p Peripheral) *Device {
+	d := a.newDevice(p)
+	d.add()
+	return d
+}
+
+// Advertise adds a device that will be discovered by the adapter.
+// It appears immediately if the adapter is discovering,
+// otherwise when discovery is started.
+func (a *Adapter) Advertise(p Peripheral) *Device {
+	d := a.newDevice(p)
+	a.mu.Lock()
+	a.advertising = append(a.advertising, d)
+	a.mu.Unlock()
+	if a.Discovering() {
+		a.discover()
+	}
+	return d
+}
+
+func (a *Adapter) startDiscovery() *dbus.Error {
+	if a.Discovering() {
+		return bluezError("InProgress", "Operation already in progress")
+	}
+	a.s.SetProperty(a.path, adapterInterface, "Discovering", true)
+	a.discover()
+	return nil
+}
+
+// discover adds the advertising devices that match the discovery filter.
+func (a *Adapter) discover() {
+	a.mu.Lock()
+	var found []*Device
+	remaining := a.advertising[:0]
+	for _, d := range a.advertising {
+		uuids, _ := a.filter["UUIDs"].Value().([]string)
+		if matchFilter(d.peripheral.UUIDs, uuids) {
+			found = append(found, d)
+		} else {
+			remaining = append(remaining, d)
+		}
+	}
+	a.advertising = remaining
+	a.mu.Unlock()
+	for _, d := range found {
+		d.add()
+	}
+}
+
+// matchFilter checks whether any of the advertised UUIDs are in the filter.
+// An empty filter matches every device.
+func matchFilter(uuids []string, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, u := range uuids {
+		for _, f := range filter {
+			if strings.EqualFold(u, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *Adapter) stopDiscovery() *dbus.Error {
+	if !a.Discovering() {
+		return bluezError("Failed", "No discovery started")
+	}
+	a.s.SetProperty(a.path, adapterInterface, "Discovering", false)
+	return nil
+}
+
+// setDiscoveryFilter records the filter.
+// Only the UUIDs key is applied to the advertising devices.
+func (a *Adapter) setDiscoveryFilter(filter Properties) *dbus.Error {
+	a.mu.Lock()
+	a.filter = copyProperties(filter)
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Adapter) getDiscoveryFilters() ([]string, *dbus.Error) {
+	return []string{"UUIDs", "RSSI", "Pathloss", "Transport", "DuplicateData", "Discoverable", "Pattern"}, nil
+}
+
+// DiscoveryFilter returns the discovery filter most recently set by a client.
+func (a *Adapter) DiscoveryFilter() Properties {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return copyProperties(a.filter)
+}
+
+func (a *Adapter) removeDevice(path dbus.ObjectPath) *dbus.Error {
+	if !strings.HasPrefix(string(path), string(a.path)+"/") || !a.s.hasObject(path) {
+		return bluezError("DoesNotExist", "Does Not Exist")
+	}
+	a.s.removeObject(path)
+	a.mu.Lock()
+	for addr, d := range a.devices {
+		if d.path == path {
+			delete(a.devices, addr)
+		}
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// Device is a virtual org.bluez.Device1 object.
+type Device struct {
+	adapter    *Adapter
+	path       dbus.ObjectPath
+	peripheral Peripheral
+
+	mu              sync.Mutex
+	gattExported    bool
+	characteristics []*Characteristic
+	profiles        map[string]bool
+	pairing         bool
+	canceled        bool
+}
+
+func (a *Adapter) newDevice(p Peripheral) *Device {
+	p.Address = strings.ToUpper(p.Address)
+	return &Device{
+		adapter:    a,
+		path:       dbus.ObjectPath(fmt.Sprintf("%s/dev_%s", a.path, strings.Replace(p.Address, ":", "_", -1))),
+		peripheral: p,
+	}
+}
+
+func (d *Device) add() {
+	p := d.peripheral
+	d.adapter.mu.Lock()
+	d.adapter.devices[p.Address] = d
+	d.adapter.mu.Unlock()
+	addressType := p.AddressType
+	if addressType == "" {
+		addressType = "public"
+	}
+	uuids := p.UUIDs
+	if uuids == nil {
+		uuids = []string{}
+	}
+	props := Properties{
+		"Address":          dbus.MakeVariant(p.Address),
+		"AddressType":      dbus.MakeVariant(addressType),
+		"Alias":            dbus.MakeVariant(p.Name),
+		"UUIDs":            dbus.MakeVariant(uuids),
+		"RSSI":             dbus.MakeVariant(p.RSSI),
+		"Adapter":          dbus.MakeVariant(d.adapter.path),
+		"Connected":        dbus.MakeVariant(false),
+		"Paired":           dbus.MakeVariant(false),
+		"Trusted":          dbus.MakeVariant(false),
+		"Blocked":          dbus.MakeVariant(false),
+		"LegacyPairing":    dbus.MakeVariant(false),
+		"ServicesResolved": dbus.MakeVariant(false),
+		"WakeAllowed":      dbus.MakeVariant(false),
+	}
+	if p.Name != "" {
+		props["Name"] = dbus.MakeVariant(p.Name)
+	}
+	for k, v := range p.Properties {
+		props[k] = dbus.MakeVariant(v)
+	}
+	d.adapter.s.addObject(d.path, map[string]Properties{deviceInterface: props}, map[string]map[string]interface{}{
+		deviceInterface: {
+			"Connect":           d.connect,
+			"Disconnect":        d.disconnect,
+			"Pair":              d.pair,
+			"CancelPairing":     d.cancelPairing,
+			"ConnectProfile":    d.connectProfile,
+			"DisconnectProfile": d.disconnectProfile,
+		},
+	})
+}
+
+// Path returns the device's D-Bus object path.
+func (d *Device) Path() dbus.ObjectPath {
+	return d.path
+}
+
+func (d *Device) boolProperty(name string) bool {
+	b, _ := d.adapter.s.Property(d.path, deviceInterface, name).Value().(bool)
+	return b
+}
+
+// Connected returns whether the device is connected.
+func (d *Device) Connected() bool {
+	return d.boolProperty("Connected")
+}
+
+// Paired returns whether the device is paired.
+func (d *Device) Paired() bool {
+	return d.boolProperty("Paired")
+}
+
+// SetProperty sets a property of the device and emits the PropertiesChanged signal.
+// It can be used to simulate changes such as a new RSSI value.
+func (d *Device) SetProperty(name string, value interface{}) {
+	d.adapter.s.SetProperty(d.path, deviceInterface, name, value)
+}
+
+// Disconnect simulates a disconnection initiated by the peripheral.
+func (d *Device) Disconnect() {
+	_ = d.disconnect()
+}
+
+// Characteristic returns the characteristic with the given UUID,
+// or nil if there is none or the device has not been connected yet.
+func (d *Device) Characteristic(uuid string) *Characteristic {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.characteristics {
+		if strings.EqualFold(c.uuid, uuid) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *Device) connect() *dbus.Error {
+	if d.Connected() {
+		return bluezError("AlreadyConnected", "Already Connected")
+	}
+	d.SetProperty("Connected", true)
+	d.exportGatt()
+	d.SetProperty("ServicesResolved", true)
+	return nil
+}
+
+func (d *Device) disconnect() *dbus.Error {
+	if !d.Connected() {
+		return bluezError("NotConnected", "Not Connected")
+	}
+	d.mu.Lock()
+	chars := d.characteristics
+	d.mu.Unlock()
+	for _, c := range chars {
+		if c.Notifying() {
+			_ = c.stopNotify()
+		}
+	}
+	d.SetProperty("ServicesResolved", false)
+	d.SetProperty("Connected", false)
+	return nil
+}
+
+func (d *Device) pair() *dbus.Error {
+	if d.Paired() {
+		return bluezError("AlreadyExists", "Already Exists")
+	}
+	d.mu.Lock()
+	if d.pairing {
+		d.mu.Unlock()
+		return bluezError("InProgress", "In Progress")
+	}
+	d.pairing = true
+	d.canceled = false
+	d.mu.Unlock()
+	err := d.authenticate()
+	d.mu.Lock()
+	d.pairing = false
+	canceled := d.canceled
+	d.mu.Unlock()
+	if canceled {
+		return bluezError("AuthenticationCanceled", "Authentication Canceled")
+	}
+	if err != nil {
+		return err
+	}
+	d.SetProperty("Paired", true)
+	return nil
+}
+
+func (d *Device) cancelPairing() *dbus.Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.pairing {
+		return bluezError("DoesNotExist", "Does Not Exist")
+	}
+	d.canceled = true
+	return nil
+}
+
+func (d *Device) connectProfile(uuid string) *dbus.Error {
+	if !d.Connected() {
+		return bluezError("NotConnected", "Not Connected")
+	}
+	if !matchFilter(d.peripheral.UUIDs, []string{uuid}) {
+		return bluezError("DoesNotExist", "Does Not Exist")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.profiles == nil {
+		d.profiles = make(map[string]bool)
+	}
+	d.profiles[strings.ToLower(uuid)] = true
+	return nil
+}
+
+func (d *Device) disconnectProfile(uuid string) *dbus.Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.profiles[strings.ToLower(uuid)] {
+		return bluezError("NotConnected", "Not Connected")
+	}
+	delete(d.profiles, strings.ToLower(uuid))
+	return nil
+}
+
+// ProfileConnected returns whether the profile with the given UUID
+// has been connected with ConnectProfile.
+func (d *Device) ProfileConnected(uuid string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.profiles[strings.ToLower(uuid)]
+}
+
+// Property returns the value of a property of the device.
+func (d *Device) Property(name string) dbus.Variant {
+	return d.adapter.s.Property(d.path, deviceInterface, name)
+}
+
+// authenticate uses the client's agent according to the pairing method.
+func (d *Device) authenticate() *dbus.Error {
+	p := d.peripheral
+	if p.Pairing == "" {
+		return nil
+	}
+	agent := d.adapter.s.Agent()
+	if agent == "" {
+		return bluezError("AuthenticationFailed", "No agent registered")
+	}
+	var call *dbus.Call
+	switch p.Pairing {
+	case "passkey":
+		call = d.adapter.s.callAgent(agent, "RequestPasskey", d.path)
+		var passkey uint32
+		if call.Err == nil && call.Store(&passkey) == nil && passkey != p.Passkey {
+			return bluezError("AuthenticationFailed", "Wrong passkey")
+		}
+	case "confirm":
+		call = d.adapter.s.callAgent(agent, "RequestConfirmation", d.path, p.Passkey)
+	default:
+		return bluezError("Failed", "Unknown pairing method %q", p.Pairing)
+	}
+	if call.Err == nil {
+		return nil
+	}
+	if e, ok := call.Err.(dbus.Error); ok && e.Name == "org.bluez.Error.Canceled" {
+		return bluezError("AuthenticationCanceled", "Authentication Canceled")
+	}
+	return bluezError("AuthenticationRejected", "Authentication Rejected")
+}
+
+// exportGatt exports the device's GATT services the first time it is connected.
+func (d *Device) exportGatt() {
+	d.mu.Lock()
+	if d.gattExported {
+		d.mu.Unlock()
+		return
+	}
+	d.gattExported = true
+	d.mu.Unlock()
+	s := d.adapter.s
+	handle := uint16(1)
+	next := func() uint16 {
+		h := handle
+		handle++
+		return h
+	}
+	for _, svc := range d.peripheral.Services {
+		h := next()
+		svcPath := dbus.ObjectPath(fmt.Sprintf("%s/service%04x", d.path, h))
+		s.addObject(svcPath, map[string]Properties{
+			serviceInterface: {
+				"UUID":     dbus.MakeVariant(svc.UUID),
+				"Device":   dbus.MakeVariant(d.path),
+				"Primary":  dbus.MakeVariant(true),
+				"Includes": dbus.MakeVariant([]dbus.ObjectPath{}),
+				"Handle":   dbus.MakeVariant(h),
+			},
+		}, nil)
+		for _, char := range svc.Characteristics {
+			c := d.exportCharacteristic(svcPath, next(), char)
+			for _, desc := range char.Descriptors {
+				c.exportDescriptor(next(), desc)
+			}
+		}
+	}
+}
+
+// Characteristic is a virtual org.bluez.GattCharacteristic1 object.
+type Characteristic struct {
+	device *Device
+	path   dbus.ObjectPath
+	uuid   string
+
+	mu      sync.Mutex
+	writes  [][]byte
+	options []Properties
+}
+
+func (d *Device) exportCharacteristic(svcPath dbus.ObjectPath, h uint16, char GattCharacteristic) *Characteristic {
+	c := &Characteristic{
+		device: d,
+		path:   dbus.ObjectPath(fmt.Sprintf("%s/char%04x", svcPath, h)),
+		uuid:   char.UUID,
+	}
+	d.mu.Lock()
+	d.characteristics = append(d.characteristics, c)
+	d.mu.Unlock()
+	props := Properties{
+		"UUID":      dbus.MakeVariant(char.UUID),
+		"Service":   dbus.MakeVariant(svcPath),
+		"Value":     dbus.MakeVariant(char.Value),
+		"Notifying": dbus.MakeVariant(false),
+		"Flags":     dbus.MakeVariant(char.Flags),
+		"Handle":    dbus.MakeVariant(h),
+	}
+	if d.peripheral.MTU != 0 {
+		props["MTU"] = dbus.MakeVariant(d.peripheral.MTU)
+	}
+	d.adapter.s.addObject(c.path, map[string]Properties{characteristicInterface: props}, map[string]map[string]interface{}{
+		characteristicInterface: {
+			"ReadValue":     c.readValue,
+			"WriteValue":    c.writeValue,
+			"StartNotify":   c.startNotify,
+			"StopNotify":    c.stopNotify,
+			"AcquireNotify": c.acquire,
+			"AcquireWrite":  c.acquire,
+		},
+	})
+	return c
+}
+
+// Path returns the characteristic's D-Bus object path.
+func (c *Characteristic) Path() dbus.ObjectPath {
+	return c.path
+}
+
+// Value returns the characteristic's current value.
+func (c *Characteristic) Value() []byte {
+	v, _ := c.device.adapter.s.Property(c.path, characteristicInterface, "Value").Value().([]byte)
+	return v
+}
+
+// Writes returns the values written to the characteristic by the client, in order.
+func (c *Characteristic) Writes() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.writes...)
+}
+
+// Notifying returns whether notifications have been enabled by the client.
+func (c *Characteristic) Notifying() bool {
+	b, _ := c.device.adapter.s.Property(c.path, characteristicInterface, "Notifying").Value().(bool)
+	return b
+}
+
+// Notify sends a notification with the given value, if notifications are enabled.
+// It returns false if they are not.
+func (c *Characteristic) Notify(value []byte) bool {
+	if !c.Notifying() {
+		return false
+	}
+	c.device.adapter.s.SetProperty(c.path, characteristicInterface, "Value", value)
+	return true
+}
+
+func (c *Characteristic) readValue(options Properties) ([]byte, *dbus.Error) {
+	if !c.device.Connected() {
+		return nil, bluezError("NotConnected", "Not Connected")
+	}
+	value := c.Value()
+	offset, _ := options["offset"].Value().(uint16)
+	if int(offset) > len(value) {
+		return nil, bluezError("InvalidOffset", "Invalid offset")
+	}
+	return value[offset:], nil
+}
+
+func (c *Characteristic) writeValue(value []byte, options Properties) *dbus.Error {
+	if !c.device.Connected() {
+		return bluezError("NotConnected", "Not Connected")
+	}
+	writeType, _ := options["type"].Value().(string)
+	if writeType == "command" && !c.hasFlag("write-without-response") {
+		return bluezError("NotSupported", "Operation is not supported")
+	}
+	if writeType == "reliable" && !c.hasFlag("reliable-write") {
+		return bluezError("NotSupported", "Operation is not supported")
+	}
+	offset, _ := options["offset"].Value().(uint16)
+	s := c.device.adapter.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, _ := s.objects[c.path][characteristicInterface]["Value"].Value().([]byte)
+	if int(offset) > len(old) {
+		return bluezError("InvalidOffset", "Invalid offset")
+	}
+	c.mu.Lock()
+	c.writes = append(c.writes, value)
+	c.options = append(c.options, copyProperties(options))
+	c.mu.Unlock()
+	v := append(append([]byte(nil), old[:offset]...), value...)
+	s.objects[c.path][characteristicInterface]["Value"] = dbus.MakeVariant(v)
+	return nil
+}
+
+// WriteOptions returns the options of the writes returned by Writes, in order.
+func (c *Characteristic) WriteOptions() []Properties {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Properties(nil), c.options...)
+}
+
+func (c *Characteristic) hasFlag(flag string) bool {
+	flags, _ := c.device.adapter.s.Property(c.path, characteristicInterface, "Flags").Value().([]string)
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire refuses AcquireNotify and AcquireWrite,
+// since file descriptors cannot be passed over the in-memory bus.
+func (c *Characteristic) acquire(options Properties) (dbus.UnixFD, uint16, *dbus.Error) {
+	return 0, 0, bluezError("NotSupported", "Operation is not supported")
+}
+
+func (c *Characteristic) startNotify() *dbus.Error {
+	if !c.device.Connected() {
+		return bluezError("NotConnected", "Not Connected")
+	}
+	if c.Notifying() {
+		return bluezError("InProgress", "Notify request already in progress")
+	}
+	c.device.adapter.s.SetProperty(c.path, characteristicInterface, "Notifying", true)
+	return nil
+}
+
+func (c *Characteristic) stopNotify() *dbus.Error {
+	if !c.Notifying() {
+		return bluezError("Failed", "No notify session started")
+	}
+	c.device.adapter.s.SetProperty(c.path, characteristicInterface, "Notifying", false)
+	return nil
+}
+
+func (c *Characteristic) exportDescriptor(h uint16, desc GattDescriptor) {
+	path := dbus.ObjectPath(fmt.Sprintf("%s/desc%04x", c.path, h))
+	s := c.device.adapter.s
+	read := func(options Properties) ([]byte, *dbus.Error) {
+		v, _ := s.Property(path, descriptorInterface, "Value").Value().([]byte)
+		return v, nil
+	}
+	write := func(value []byte, options Properties) *dbus.Error {
+		s.mu.Lock()
+		s.objects[path][descriptorInterface]["Value"] = dbus.MakeVariant(value)
+		s.mu.Unlock()
+		return nil
+	}
+	s.addObject(path, map[string]Properties{
+		descriptorInterface: {
+			"UUID":           dbus.MakeVariant(desc.UUID),
+			"Characteristic": dbus.MakeVariant(c.path),
+			"Value":          dbus.MakeVariant(desc.Value),
+			"Flags":          dbus.MakeVariant(desc.Flags),
+			"Handle":         dbus.MakeVariant(h),
+		},
+	}, map[string]map[string]interface{}{
+		descriptorInterface: {
+			"ReadValue":  read,
+			"WriteValue": write,
+		},
+	})
+}
@@ -0,0 +1,393 @@
+/*
+Package bletest provides an in-process fake of the BlueZ D-Bus service,
+for hermetic testing of the ble package and programs that use it.
+
+A Server exports the org.bluez ObjectManager, Adapter1, Device1,
+GattService1, GattCharacteristic1, and GattDescriptor1 interfaces
+on an in-memory D-Bus connection.  Virtual peripherals are added
+to its adapters and can be scripted from the test:
+
+	server, err := bletest.NewServer()
+	...
+	defer server.Close()
+	adapter := server.AddAdapter("hci0", "00:11:22:33:44:55")
+	adapter.Advertise(bletest.Peripheral{
+		Address: "AA:BB:CC:DD:EE:FF",
+		Name:    "sensor",
+		UUIDs:   []string{"0000180d-0000-1000-8000-00805f9b34fb"},
+	})
+	conn, err := ble.OpenConn(server.Transport())
+	...
+
+The AgentManager1 and GattManager1 interfaces are also implemented,
+so the client's Agent and GATT application can be exercised.
+*/
+package bletest
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	objectManager       = "org.freedesktop.DBus.ObjectManager"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+
+	agentInterface          = "org.bluez.Agent1"
+	agentManagerInterface   = "org.bluez.AgentManager1"
+	gattManagerInterface    = "org.bluez.GattManager1"
+	adapterInterface        = "org.bluez.Adapter1"
+	deviceInterface         = "org.bluez.Device1"
+	serviceInterface        = "org.bluez.GattService1"
+	characteristicInterface = "org.bluez.GattCharacteristic1"
+	descriptorInterface     = "org.bluez.GattDescriptor1"
+
+	// clientName is the unique bus name assigned to the client by Hello.
+	clientName = ":1.1"
+)
+
+// Properties represents the properties of a D-Bus interface.
+type Properties = map[string]dbus.Variant
+
+// A CallHook is called before every method call handled by the server,
+// with the path of the object and the full name of the method
+// (such as "org.bluez.Device1.Connect").
+// If it returns a non-nil error, the call fails with that error.
+// It may also block, to simulate a slow operation.
+type CallHook func(path dbus.ObjectPath, method string) *dbus.Error
+
+// Server is a fake BlueZ D-Bus service.
+type Server struct {
+	bus  *pipeBus
+	conn *dbus.Conn
+
+	mu       sync.Mutex
+	objects  map[dbus.ObjectPath]map[string]Properties
+	failures map[string][]*dbus.Error
+	hook     CallHook
+	adapters []*Adapter
+	agent    dbus.ObjectPath
+}
+
+// NewServer creates a fake BlueZ service with no adapters.
+func NewServer() (*Server, error) {
+	bus := newPipeBus()
+	conn, err := dbus.NewConn(bus.server)
+	if err != nil {
+		bus.close()
+		return nil, err
+	}
+	err = conn.Auth(nil)
+	if err != nil {
+		bus.close()
+		return nil, err
+	}
+	s := &Server{
+		bus:      bus,
+		conn:     conn,
+		objects:  make(map[dbus.ObjectPath]map[string]Properties),
+		failures: make(map[string][]*dbus.Error),
+	}
+	err = s.exportBus()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go s.receiveSignals(signals)
+	return s, nil
+}
+
+// Transport returns the client end of the server's D-Bus connection,
+// for use with ble.OpenConn.  Only one client can be connected.
+func (s *Server) Transport() io.ReadWriteCloser {
+	return s.bus.client
+}
+
+// Close shuts down the server and its D-Bus connection.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	s.bus.close()
+	return err
+}
+
+// Adapter returns the adapter with the given name, or nil if there is none.
+func (s *Server) Adapter(name string) *Adapter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.adapters {
+		if a.path == dbus.ObjectPath("/org/bluez/"+name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// SetCallHook installs a function to be called before every method call.
+func (s *Server) SetCallHook(hook CallHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+// FailNext causes the next call of the given method
+// (such as "org.bluez.Device1.Connect") on the object with the given path
+// to fail with the given D-Bus error name.
+func (s *Server) FailNext(path dbus.ObjectPath, method string, errorName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(path) + " " + method
+	s.failures[key] = append(s.failures[key], dbus.NewError(errorName, []interface{}{"bletest: injected failure"}))
+}
+
+func (s *Server) checkCall(path dbus.ObjectPath, method string) *dbus.Error {
+	s.mu.Lock()
+	key := string(path) + " " + method
+	var err *dbus.Error
+	if errs := s.failures[key]; len(errs) != 0 {
+		err = errs[0]
+		s.failures[key] = errs[1:]
+	}
+	hook := s.hook
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if hook != nil {
+		return hook(path, method)
+	}
+	return nil
+}
+
+// wrap returns a method that calls checkCall before calling fn,
+// which must be a function whose last result is a *dbus.Error.
+func (s *Server) wrap(path dbus.ObjectPath, method string, fn interface{}) interface{} {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		err := s.checkCall(path, method)
+		if err == nil {
+			return v.Call(args)
+		}
+		results := make([]reflect.Value, t.NumOut())
+		for i := range results[:len(results)-1] {
+			results[i] = reflect.Zero(t.Out(i))
+		}
+		results[len(results)-1] = reflect.ValueOf(err)
+		return results
+	}).Interface()
+}
+
+// export exports the given methods of the interface at the given path.
+func (s *Server) export(path dbus.ObjectPath, iface string, methods map[string]interface{}) error {
+	table := make(map[string]interface{}, len(methods))
+	for name, fn := range methods {
+		table[name] = s.wrap(path, iface+"."+name, fn)
+	}
+	return s.conn.ExportMethodTable(table, path, iface)
+}
+
+// exportBus exports the methods of the message bus itself
+// and the BlueZ object manager.
+func (s *Server) exportBus() error {
+	err := s.conn.ExportMethodTable(map[string]interface{}{
+		"Hello":       func() (string, *dbus.Error) { return clientName, nil },
+		"AddMatch":    func(string) *dbus.Error { return nil },
+		"RemoveMatch": func(string) *dbus.Error { return nil },
+	}, "/org/freedesktop/DBus", "org.freedesktop.DBus")
+	if err != nil {
+		return err
+	}
+	err = s.export("/org/bluez", agentManagerInterface, map[string]interface{}{
+		"RegisterAgent": func(path dbus.ObjectPath, capability string) *dbus.Error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.agent != "" {
+				return bluezError("AlreadyExists", "Already Exists")
+			}
+			s.agent = path
+			return nil
+		},
+		"RequestDefaultAgent": func(path dbus.ObjectPath) *dbus.Error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.agent != path {
+				return bluezError("DoesNotExist", "Does Not Exist")
+			}
+			return nil
+		},
+		"UnregisterAgent": func(path dbus.ObjectPath) *dbus.Error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.agent != path {
+				return bluezError("DoesNotExist", "Does Not Exist")
+			}
+			s.agent = ""
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return s.export("/", objectManager, map[string]interface{}{
+		"GetManagedObjects": func() (map[dbus.ObjectPath]map[string]Properties, *dbus.Error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			objects := make(map[dbus.ObjectPath]map[string]Properties, len(s.objects))
+			for path, dict := range s.objects {
+				objects[path] = copyObject(dict)
+			}
+			return objects, nil
+		},
+	})
+}
+
+// Agent returns the path of the agent registered by the client,
+// or "" if there is none.
+func (s *Server) Agent() dbus.ObjectPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.agent
+}
+
+// callAgent calls a method of the given agent.
+func (s *Server) callAgent(agent dbus.ObjectPath, method string, args ...interface{}) *dbus.Call {
+	return s.conn.Object(clientName, agent).Call(agentInterface+"."+method, 0, args...)
+}
+
+// emit emits a signal if a client is attached.
+func (s *Server) emit(path dbus.ObjectPath, name string, values ...interface{}) {
+	select {
+	case <-s.bus.attached:
+		_ = s.conn.Emit(path, name, values...)
+	default:
+	}
+}
+
+// addObject adds an object with the given interfaces and their methods,
+// and emits the InterfacesAdded signal.
+func (s *Server) addObject(path dbus.ObjectPath, dict map[string]Properties, methods map[string]map[string]interface{}) {
+	for iface, table := range methods {
+		err := s.export(path, iface, table)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err := s.export(path, propertiesInterface, s.propertiesMethods(path))
+	if err != nil {
+		panic(err)
+	}
+	s.mu.Lock()
+	s.objects[path] = dict
+	added := copyObject(dict)
+	s.mu.Unlock()
+	s.emit("/", objectManager+".InterfacesAdded", path, added)
+}
+
+// removeObject removes the object with the given path and all its descendants,
+// and emits the InterfacesRemoved signal for each of them.
+func (s *Server) removeObject(path dbus.ObjectPath) {
+	s.mu.Lock()
+	removed := make(map[dbus.ObjectPath][]string)
+	for p, dict := range s.objects {
+		if p != path && !strings.HasPrefix(string(p), string(path)+"/") {
+			continue
+		}
+		for iface := range dict {
+			removed[p] = append(removed[p], iface)
+		}
+		delete(s.objects, p)
+	}
+	s.mu.Unlock()
+	for p, ifaces := range removed {
+		for _, iface := range ifaces {
+			_ = s.conn.Export(nil, p, iface)
+		}
+		_ = s.conn.Export(nil, p, propertiesInterface)
+		s.emit("/", objectManager+".InterfacesRemoved", p, ifaces)
+	}
+}
+
+func (s *Server) hasObject(path dbus.ObjectPath) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[path] != nil
+}
+
+// Property returns the value of a property of the object with the given path.
+func (s *Server) Property(path dbus.ObjectPath, iface string, name string) dbus.Variant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[path][iface][name]
+}
+
+// SetProperty sets a property of the object with the given path
+// and emits the PropertiesChanged signal.
+func (s *Server) SetProperty(path dbus.ObjectPath, iface string, name string, value interface{}) {
+	v := dbus.MakeVariant(value)
+	s.mu.Lock()
+	props := s.objects[path][iface]
+	if props == nil {
+		s.mu.Unlock()
+		return
+	}
+	props[name] = v
+	s.mu.Unlock()
+	s.emit(path, propertiesInterface+".PropertiesChanged", iface, Properties{name: v}, []string{})
+}
+
+func (s *Server) propertiesMethods(path dbus.ObjectPath) map[string]interface{} {
+	return map[string]interface{}{
+		"Get": func(iface string, name string) (dbus.Variant, *dbus.Error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			v, ok := s.objects[path][iface][name]
+			if !ok {
+				return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"No such property " + name})
+			}
+			return v, nil
+		},
+		"GetAll": func(iface string) (Properties, *dbus.Error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return copyProperties(s.objects[path][iface]), nil
+		},
+		"Set": func(iface string, name string, value dbus.Variant) *dbus.Error {
+			s.mu.Lock()
+			_, ok := s.objects[path][iface][name]
+			s.mu.Unlock()
+			if !ok {
+				return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{"No such property " + name})
+			}
+			s.SetProperty(path, iface, name, value.Value())
+			return nil
+		},
+	}
+}
+
+func copyObject(dict map[string]Properties) map[string]Properties {
+	c := make(map[string]Properties, len(dict))
+	for iface, props := range dict {
+		c[iface] = copyProperties(props)
+	}
+	return c
+}
+
+func copyProperties(props Properties) Properties {
+	c := make(Properties, len(props))
+	for k, v := range props {
+		c[k] = v
+	}
+	return c
+}
+
+// bluezError returns a BlueZ error with the given short name, such as "NotConnected".
+func bluezError(name string, format string, args ...interface{}) *dbus.Error {
+	return dbus.NewError("org.bluez.Error."+name, []interface{}{fmt.Sprintf(format, args...)})
+}
@@ -12,9 +12,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	adapter, err := conn.GetAdapter()
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) == 2 {
+		adapter, err := conn.GetAdapterByName(os.Args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		adapter.Print(os.Stdout)
+		return
+	}
+	adapters := conn.Adapters()
+	if len(adapters) == 0 {
+		log.Fatal("no adapters found")
+	}
+	for _, adapter := range adapters {
+		adapter.Print(os.Stdout)
 	}
-	adapter.Print(os.Stdout)
 }
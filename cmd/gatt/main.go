@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ecc1/ble"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: %s (address|name)", os.Args[0])
+	}
+	d := os.Args[1]
+	conn, err := ble.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var device ble.Device
+	if ble.ValidAddress(d) {
+		device, err = conn.GetDeviceByAddress(ble.Address(d))
+	} else {
+		device, err = conn.GetDeviceByName(d)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range device.Services() {
+		fmt.Printf("service %s handle %#04x primary %v\n", ble.ShortUUID(service.UUID()), service.Handle(), service.Primary())
+		for _, char := range service.Characteristics() {
+			fmt.Printf("    characteristic %s handle %#04x %v\n", ble.ShortUUID(char.UUID()), char.Handle(), char.Flags())
+			for _, desc := range char.Descriptors() {
+				fmt.Printf("        descriptor %s handle %#04x %v\n", ble.ShortUUID(desc.UUID()), desc.Handle(), desc.Flags())
+			}
+		}
+	}
+}
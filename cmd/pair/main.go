@@ -3,18 +3,30 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/ecc1/ble"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("Usage: %s UUID", os.Args[0])
+	if len(os.Args) != 2 && len(os.Args) != 3 {
+		log.Fatalf("Usage: %s UUID [passkey]", os.Args[0])
 	}
 	conn, err := ble.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(os.Args) == 3 {
+		passkey, err := strconv.ParseUint(os.Args[2], 10, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = conn.RegisterAgent(ble.FixedPasskeyAgent{Passkey: uint32(passkey)}, ble.KeyboardDisplay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.UnregisterAgent()
+	}
 	device, err := conn.Discover(0, "", os.Args[1])
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ecc1/ble"
+)
+
+// Provide a heart rate service with a simulated measurement.
+// Advertising the service is out of scope for this example;
+// use bluetoothctl's advertise command, or connect to the adapter
+// from a central that already knows its address.
+func main() {
+	backend, err := ble.SystemBackend()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Notifications are sent as PropertiesChanged signals.
+	if _, ok := backend.(ble.Emitter); !ok {
+		log.Fatal("backend cannot send notifications")
+	}
+	conn, err := ble.OpenBackend(backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	adapter, err := conn.GetAdapter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	measurement := &ble.LocalCharacteristic{
+		UUID:  "2a37",
+		Flags: ble.FlagNotify,
+	}
+	location := &ble.LocalCharacteristic{
+		UUID:  "2a38",
+		Flags: ble.FlagRead,
+		Value: []byte{1},
+	}
+	app, err := adapter.RegisterApplication(&ble.LocalService{
+		UUID:            "180d",
+		Characteristics: []*ble.LocalCharacteristic{measurement, location},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(time.Second)
+	rate := byte(60)
+	for {
+		select {
+		case <-interrupt:
+			ticker.Stop()
+			err = app.Unregister()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case <-ticker.C:
+		}
+		rate++
+		if rate > 100 {
+			rate = 60
+		}
+		err = measurement.Update([]byte{0, rate})
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
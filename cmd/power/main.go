@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ecc1/ble"
+)
+
+func main() {
+	if len(os.Args) > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [on|off]\n", os.Args[0])
+		os.Exit(1)
+	}
+	conn, err := ble.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	adapter, err := conn.GetAdapter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) == 2 {
+		err = adapter.SetPowered(os.Args[1] == "on")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	powered, err := adapter.Powered()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s powered: %v\n", adapter.Name(), powered)
+}
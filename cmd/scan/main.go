@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/ecc1/ble"
+)
+
+func main() {
+	conn, err := ble.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	adapter, err := conn.GetAdapter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	events, err := adapter.Scan(ctx, ble.DiscoveryFilter{UUIDs: os.Args[1:]})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for e := range events {
+		fmt.Printf("%s %s (%s)\n", e.Type, e.Device.Address(), e.Device.Name())
+		for key, val := range e.Changed {
+			fmt.Printf("    %s %s\n", key, val)
+		}
+	}
+}
@@ -0,0 +1,1357 @@
+package ble_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+
+	"github.com/ecc1/ble"
+	"github.com/ecc1/ble/bletest"
+)
+
+const (
+	heartRateService     = "0000180d-0000-1000-8000-00805f9b34fb"
+	heartRateMeasurement = "00002a37-0000-1000-8000-00805f9b34fb"
+	bodySensorLocation   = "00002a38-0000-1000-8000-00805f9b34fb"
+	clientConfiguration  = "00002902-0000-1000-8000-00805f9b34fb"
+
+	testTimeout = 5 * time.Second
+)
+
+var heartRateMonitor = bletest.Peripheral{
+	Address: "aa:bb:cc:dd:ee:01",
+	Name:    "HRM",
+	UUIDs:   []string{heartRateService},
+	RSSI:    -60,
+	Services: []bletest.GattService{{
+		UUID: heartRateService,
+		Characteristics: []bletest.GattCharacteristic{
+			{
+				UUID:  heartRateMeasurement,
+				Flags: []string{"notify"},
+				Descriptors: []bletest.GattDescriptor{
+					{UUID: clientConfiguration, Flags: []string{"read", "write"}},
+				},
+			},
+			{
+				UUID:  bodySensorLocation,
+				Flags: []string{"read", "write"},
+				Value: []byte{1},
+			},
+		},
+	}},
+}
+
+func setup(t *testing.T) (*bletest.Server, *bletest.Adapter, *ble.Connection) {
+	t.Helper()
+	server, err := bletest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := server.AddAdapter("hci0", "00:11:22:33:44:55")
+	conn, err := ble.OpenConn(server.Transport())
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Close()
+	})
+	return server, adapter, conn
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func connectDevice(t *testing.T, conn *ble.Connection) ble.Device {
+	t.Helper()
+	device, err := conn.DiscoverContext(testContext(t), "", heartRateService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = device.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func TestDiscover(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(bletest.Peripheral{Address: "aa:bb:cc:dd:ee:00", Name: "other"})
+	adapter.Advertise(heartRateMonitor)
+	device, err := conn.DiscoverContext(testContext(t), "", heartRateService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Address() != "AA:BB:CC:DD:EE:01" || device.Name() != "HRM" {
+		t.Errorf("discovered %s (%s), want AA:BB:CC:DD:EE:01 (HRM)", device.Address(), device.Name())
+	}
+	if adapter.Discovering() {
+		t.Errorf("adapter still discovering")
+	}
+}
+
+func TestDiscoverDevice(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	adapter.Advertise(bletest.Peripheral{Address: "aa:bb:cc:dd:ee:00", Name: "other"})
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := a.DiscoverDevice("aa:bb:cc:dd:ee:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Name() != "other" {
+		t.Errorf("DiscoverDevice found %s, want other", device.Name())
+	}
+}
+
+func TestDiscoverTimeout(t *testing.T) {
+	_, _, conn := setup(t)
+	_, err := conn.Discover(100*time.Millisecond, "", heartRateService)
+	var timeoutErr ble.DiscoveryTimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ble.ErrTimeout) {
+		t.Errorf("Discover returned %v, want DiscoveryTimeoutError", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conn.DiscoverContext(ctx, "", heartRateService)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DiscoverContext returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestScan(t *testing.T) {
+	server, adapter, conn := setup(t)
+	other := adapter.AddDevice(bletest.Peripheral{Address: "aa:bb:cc:dd:ee:00", Name: "other"})
+	adapter.Advertise(heartRateMonitor)
+	ctx, cancel := context.WithCancel(testContext(t))
+	defer cancel()
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := a.Scan(ctx, ble.DiscoveryFilter{UUIDs: []string{heartRateService}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(want ble.ScanEventType) ble.ScanEvent {
+		t.Helper()
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("scan channel closed")
+			}
+			if e.Type != want {
+				t.Fatalf("scan event %v, want %v", e.Type, want)
+			}
+			return e
+		case <-time.After(testTimeout):
+			t.Fatalf("timeout waiting for %v event", want)
+		}
+		return ble.ScanEvent{}
+	}
+	e := next(ble.DeviceFound)
+	if e.Device.Address() != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("found device %s", e.Device.Address())
+	}
+	_, err = a.Scan(ctx, ble.DiscoveryFilter{})
+	if !errors.Is(err, ble.ErrInProgress) {
+		t.Errorf("second Scan returned %v, want %v", err, ble.ErrInProgress)
+	}
+	// Another filter applied to the adapter must not affect this scan.
+	err = a.ApplyDiscoveryFilter(ctx, ble.DiscoveryFilter{UUIDs: []string{heartRateService}, Pattern: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A known device without the UUID must not be reported.
+	other.SetProperty("RSSI", int16(-50))
+	d := adapter.Device("aa:bb:cc:dd:ee:01")
+	d.SetProperty("RSSI", int16(-40))
+	e = next(ble.DeviceUpdated)
+	if e.Device.Address() != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("updated device %s", e.Device.Address())
+	}
+	if rssi := e.Changed["RSSI"].Value(); rssi != int16(-40) {
+		t.Errorf("updated RSSI = %v", rssi)
+	}
+	err = a.RemoveDevice(e.Device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(ble.DeviceLost)
+	cancel()
+	for range events {
+	}
+	if server.Adapter("hci0").Discovering() {
+		t.Errorf("adapter still discovering after scan")
+	}
+	ctx, cancel = context.WithCancel(testContext(t))
+	events, err = a.Scan(ctx, ble.DiscoveryFilter{})
+	if err != nil {
+		t.Fatalf("Scan after previous scan ended returned %v", err)
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestAdvertisementData(t *testing.T) {
+	_, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.Properties = map[string]interface{}{
+		"TxPower":          int16(4),
+		"Appearance":       uint16(0x0340),
+		"Icon":             "heart-rate-monitor",
+		"ManufacturerData": map[uint16]dbus.Variant{0x004c: dbus.MakeVariant([]byte{1, 2})},
+		"ServiceData":      map[string]dbus.Variant{heartRateService: dbus.MakeVariant([]byte{3})},
+		"AdvertisingFlags": []byte{0x06},
+		"AdvertisingData":  map[byte]dbus.Variant{0xff: dbus.MakeVariant([]byte{0x4c, 0, 1, 2})},
+	}
+	adapter.AddDevice(p)
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := conn.GetDeviceByAddress("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rssi, ok := device.RSSI(); !ok || rssi != -60 {
+		t.Errorf("RSSI() = %d, %v", rssi, ok)
+	}
+	if power, ok := device.TxPower(); !ok || power != 4 {
+		t.Errorf("TxPower() = %d, %v", power, ok)
+	}
+	if device.Appearance() != 0x0340 || device.Icon() != "heart-rate-monitor" || device.Alias() != "HRM" {
+		t.Errorf("Appearance() = %#x, Icon() = %q, Alias() = %q", device.Appearance(), device.Icon(), device.Alias())
+	}
+	if device.Trusted() || device.Blocked() || device.ServicesResolved() || device.Class() != 0 {
+		t.Errorf("unexpected Trusted, Blocked, ServicesResolved, or Class")
+	}
+	if data := device.ManufacturerData()[0x004c]; !bytes.Equal(data, []byte{1, 2}) {
+		t.Errorf("ManufacturerData() = %v", device.ManufacturerData())
+	}
+	if data := device.ServiceData()[heartRateService]; !bytes.Equal(data, []byte{3}) {
+		t.Errorf("ServiceData() = %v", device.ServiceData())
+	}
+	if !bytes.Equal(device.AdvertisingFlags(), []byte{0x06}) {
+		t.Errorf("AdvertisingFlags() = %v", device.AdvertisingFlags())
+	}
+	if data := device.AdvertisingData()[0xff]; !bytes.Equal(data, []byte{0x4c, 0, 1, 2}) {
+		t.Errorf("AdvertisingData() = %v", device.AdvertisingData())
+	}
+}
+
+func TestDiscoveryFilter(t *testing.T) {
+	_, adapter, conn := setup(t)
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters, err := a.GetDiscoveryFilters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) == 0 {
+		t.Errorf("GetDiscoveryFilters returned no keys")
+	}
+	err = a.ApplyDiscoveryFilter(testContext(t), ble.DiscoveryFilter{RSSI: -70, Pattern: "HRM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With the nofilter build tag, no filter is sent to BlueZ.
+	filter := adapter.DiscoveryFilter()
+	if len(filter) == 0 {
+		return
+	}
+	if rssi := filter["RSSI"].Value(); rssi != int16(-70) {
+		t.Errorf("server received RSSI %v, want -70", rssi)
+	}
+	if pattern := filter["Pattern"].Value(); pattern != "HRM" {
+		t.Errorf("server received Pattern %v, want HRM", pattern)
+	}
+}
+
+func TestDiscoverMatching(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(bletest.Peripheral{
+		Address:     "c0:00:00:00:00:02",
+		AddressType: "random",
+		RSSI:        -80,
+		Properties: map[string]interface{}{
+			"ManufacturerData": map[uint16]dbus.Variant{0x0059: dbus.MakeVariant([]byte{0x12, 0x34, 0x56})},
+		},
+	})
+	match := ble.MatchAll(
+		ble.MatchAddressType("random"),
+		ble.MatchManufacturerData(0x0059, []byte{0x10, 0x34}, []byte{0xF0, 0xFF}),
+		ble.MatchMinRSSI(-90),
+	)
+	device, err := conn.DiscoverMatching(testContext(t), ble.DiscoveryFilter{}, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Address() != "C0:00:00:00:00:02" {
+		t.Errorf("discovered %s, want beacon", device.Address())
+	}
+	adapter.Advertise(heartRateMonitor)
+	device, err = conn.DiscoverMatching(testContext(t), ble.DiscoveryFilter{}, ble.MatchNameRegexp(regexp.MustCompile("^H.M$")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Name() != "HRM" {
+		t.Errorf("discovered %s, want HRM", device.Name())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = conn.DiscoverMatching(ctx, ble.DiscoveryFilter{}, ble.MatchName("missing"))
+	if !errors.Is(err, ble.ErrTimeout) {
+		t.Errorf("DiscoverMatching returned %v, want timeout", err)
+	}
+}
+
+func TestDiscoverAll(t *testing.T) {
+	_, adapter, conn := setup(t)
+	for i, rssi := range []int16{-70, -50, -90} {
+		adapter.Advertise(bletest.Peripheral{
+			Address: fmt.Sprintf("aa:bb:cc:dd:ee:1%d", i),
+			Name:    fmt.Sprintf("unit%d", i),
+			RSSI:    rssi,
+		})
+	}
+	adapter.Advertise(heartRateMonitor)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	devices, err := conn.DiscoverAll(ctx, ble.DiscoveryFilter{}, ble.MatchNamePrefix("unit"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, device := range devices {
+		names = append(names, device.Name())
+	}
+	if fmt.Sprint(names) != "[unit1 unit0 unit2]" {
+		t.Errorf("DiscoverAll returned %v", names)
+	}
+	if adapter.Discovering() {
+		t.Errorf("adapter still discovering")
+	}
+}
+
+func TestDiscoverClosed(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	scanning := make(chan struct{})
+	var once sync.Once
+	match := func(ble.Device) bool {
+		once.Do(func() { close(scanning) })
+		return false
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.DiscoverMatching(testContext(t), ble.DiscoveryFilter{}, match)
+		done <- err
+	}()
+	select {
+	case <-scanning:
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for scan")
+	}
+	conn.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ble.ErrNotReady) {
+			t.Errorf("DiscoverMatching returned %v, want %v", err, ble.ErrNotReady)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("DiscoverMatching did not return after Close")
+	}
+}
+
+func TestAdapterProperties(t *testing.T) {
+	server, adapter, conn := setup(t)
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SetPowered(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Property(adapter.Path(), "org.bluez.Adapter1", "Powered").Value() != false {
+		t.Errorf("adapter still powered")
+	}
+	powered, err := a.Powered()
+	if err != nil || powered {
+		t.Errorf("Powered() = %v, %v", powered, err)
+	}
+	err = a.SetDiscoverableTimeout(2 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := server.Property(adapter.Path(), "org.bluez.Adapter1", "DiscoverableTimeout").Value(); v != uint32(120) {
+		t.Errorf("DiscoverableTimeout = %v", v)
+	}
+	err = a.SetDiscoverableTimeout(500 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := server.Property(adapter.Path(), "org.bluez.Adapter1", "DiscoverableTimeout").Value(); v != uint32(1) {
+		t.Errorf("DiscoverableTimeout = %v, want 1", v)
+	}
+	err = a.SetDiscoverableTimeout(-time.Second)
+	if !errors.Is(err, ble.ErrInvalidArguments) {
+		t.Errorf("SetDiscoverableTimeout(-1s) returned %v, want %v", err, ble.ErrInvalidArguments)
+	}
+	err = a.SetAlias("kiosk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Alias() != "kiosk" {
+		t.Errorf("Alias() = %q", a.Alias())
+	}
+	roles, err := a.Roles()
+	if err != nil || fmt.Sprint(roles) != "[central peripheral]" {
+		t.Errorf("Roles() = %v, %v", roles, err)
+	}
+	modalias, err := a.Modalias()
+	if err != nil || modalias == "" {
+		t.Errorf("Modalias() = %q, %v", modalias, err)
+	}
+	if a.AddressType() != "public" {
+		t.Errorf("AddressType() = %q", a.AddressType())
+	}
+	_, err = a.GetProperty(testContext(t), "NoSuchProperty")
+	if !errors.Is(err, ble.ErrInvalidArguments) {
+		t.Errorf("GetProperty returned %v, want %v", err, ble.ErrInvalidArguments)
+	}
+}
+
+func TestConnectAndPair(t *testing.T) {
+	server, adapter, conn := setup(t)
+	d := adapter.AddDevice(heartRateMonitor)
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := conn.GetDeviceByAddress("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.FailNext(d.Path(), "org.bluez.Device1.Connect", "org.bluez.Error.InProgress")
+	err = device.Connect()
+	if !errors.Is(err, ble.ErrInProgress) || !ble.Temporary(err) {
+		t.Errorf("Connect returned %v, want %v", err, ble.ErrInProgress)
+	}
+	err = device.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = device.Connect()
+	if !errors.Is(err, ble.ErrAlreadyConnected) {
+		t.Errorf("Connect returned %v, want %v", err, ble.ErrAlreadyConnected)
+	}
+	err = device.PairContext(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Connected() || !d.Paired() {
+		t.Errorf("device not connected and paired")
+	}
+	err = conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !device.Connected() || !device.Paired() {
+		t.Errorf("device properties not updated")
+	}
+}
+
+// cancelingAgent cancels every confirmation request.
+type cancelingAgent struct {
+	ble.AutoAcceptAgent
+}
+
+func (cancelingAgent) RequestConfirmation(device ble.Device, passkey uint32) error {
+	return ble.ErrCanceled
+}
+
+func TestAgent(t *testing.T) {
+	server, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.Pairing = "passkey"
+	p.Passkey = 123456
+	adapter.AddDevice(p)
+	adapter.AddDevice(bletest.Peripheral{
+		Address: "aa:bb:cc:dd:ee:02",
+		Name:    "confirm",
+		Pairing: "confirm",
+		Passkey: 654321,
+	})
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrm, err := conn.GetDeviceByAddress("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := conn.GetDeviceByAddress("aa:bb:cc:dd:ee:02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hrm.Pair()
+	if !errors.Is(err, ble.ErrAuthenticationFailed) {
+		t.Errorf("Pair without agent returned %v, want %v", err, ble.ErrAuthenticationFailed)
+	}
+	err = conn.RegisterAgent(ble.FixedPasskeyAgent{Passkey: 123456}, ble.KeyboardDisplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Agent() != ble.AgentPath {
+		t.Errorf("agent path = %q", server.Agent())
+	}
+	err = hrm.Pair()
+	if err != nil {
+		t.Errorf("Pair with passkey returned %v", err)
+	}
+	err = other.Pair()
+	if !errors.Is(err, ble.ErrAuthenticationRejected) {
+		t.Errorf("Pair with wrong confirmation returned %v, want %v", err, ble.ErrAuthenticationRejected)
+	}
+	err = conn.UnregisterAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.RegisterAgent(cancelingAgent{}, ble.DisplayYesNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Pair()
+	if !errors.Is(err, ble.ErrAuthenticationCanceled) {
+		t.Errorf("Pair with canceled confirmation returned %v, want %v", err, ble.ErrAuthenticationCanceled)
+	}
+	err = conn.UnregisterAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.RegisterAgent(ble.AutoAcceptAgent{}, ble.NoInputNoOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Pair()
+	if err != nil {
+		t.Errorf("Pair with auto-accept returned %v", err)
+	}
+}
+
+// blockingAgent blocks confirmation requests until released.
+type blockingAgent struct {
+	ble.AutoAcceptAgent
+	requested chan struct{}
+	release   chan struct{}
+}
+
+func (a blockingAgent) RequestConfirmation(device ble.Device, passkey uint32) error {
+	close(a.requested)
+	<-a.release
+	return nil
+}
+
+func TestDeviceManagement(t *testing.T) {
+	_, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.Pairing = "confirm"
+	adapter.Advertise(p)
+	device := connectDevice(t, conn)
+	d := adapter.Device("aa:bb:cc:dd:ee:01")
+	err := device.ConnectProfile("180d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.ProfileConnected(heartRateService) {
+		t.Errorf("profile not connected")
+	}
+	err = device.DisconnectProfile(heartRateService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = device.DisconnectProfile(heartRateService)
+	if !errors.Is(err, ble.ErrNotConnected) {
+		t.Errorf("DisconnectProfile returned %v, want %v", err, ble.ErrNotConnected)
+	}
+	err = device.ConnectProfile("not a UUID")
+	if !errors.Is(err, ble.ErrInvalidArguments) {
+		t.Errorf("ConnectProfile returned %v, want %v", err, ble.ErrInvalidArguments)
+	}
+	for _, set := range []func(bool) error{device.SetTrusted, device.SetBlocked, device.SetWakeAllowed} {
+		err = set(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = device.SetAlias("chest strap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !device.Trusted() || !device.Blocked() || !device.WakeAllowed() || device.Alias() != "chest strap" {
+		t.Errorf("properties not set")
+	}
+	if d.Property("Trusted").Value() != true {
+		t.Errorf("Trusted not set in server")
+	}
+	err = device.CancelPairing()
+	if !errors.Is(err, ble.ErrDoesNotExist) {
+		t.Errorf("CancelPairing returned %v, want %v", err, ble.ErrDoesNotExist)
+	}
+	agent := blockingAgent{requested: make(chan struct{}), release: make(chan struct{})}
+	err = conn.RegisterAgent(agent, ble.DisplayYesNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error)
+	go func() {
+		result <- device.PairContext(testContext(t))
+	}()
+	<-agent.requested
+	err = device.CancelPairing()
+	close(agent.release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-result
+	if !errors.Is(err, ble.ErrAuthenticationCanceled) {
+		t.Errorf("Pair returned %v, want %v", err, ble.ErrAuthenticationCanceled)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	server, adapter, conn := setup(t)
+	d := adapter.AddDevice(heartRateMonitor)
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := conn.GetDeviceByName("HRM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	server.SetCallHook(func(dbus.ObjectPath, string) *dbus.Error {
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = device.ConnectContext(ctx)
+	if !errors.Is(err, ble.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ConnectContext returned %v, want timeout", err)
+	}
+	if d.Connected() {
+		t.Errorf("device connected before call was released")
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	server, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	d := server.Adapter("hci0").Device("AA:BB:CC:DD:EE:01")
+	char, err := conn.GetCharacteristic(bodySensorLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := char.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{1}) {
+		t.Errorf("ReadValue returned %v, want [1]", data)
+	}
+	err = char.WriteValueContext(testContext(t), []byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := d.Characteristic(bodySensorLocation).Writes()
+	if len(writes) != 1 || !bytes.Equal(writes[0], []byte{2}) {
+		t.Errorf("server received writes %v, want [[2]]", writes)
+	}
+	desc, err := conn.GetDescriptor(clientConfiguration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = desc.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	_, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.Services = []bletest.GattService{{
+		UUID: heartRateService,
+		Characteristics: []bletest.GattCharacteristic{{
+			UUID:  bodySensorLocation,
+			Flags: []string{"read", "write", "write-without-response"},
+			Value: []byte{1, 2, 3, 4},
+		}},
+	}}
+	d := adapter.Advertise(p)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(bodySensorLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := char.ReadValueAt(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{3, 4}) {
+		t.Errorf("ReadValueAt returned %v, want [3 4]", data)
+	}
+	_, err = char.ReadValueAt(5)
+	if !errors.Is(err, ble.ErrInvalidOffset) {
+		t.Errorf("ReadValueAt returned %v, want %v", err, ble.ErrInvalidOffset)
+	}
+	err = char.WriteValueWithOptions([]byte{5, 6}, ble.WriteOptions{Type: ble.WriteCommand, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.Characteristic(bodySensorLocation)
+	if v := c.Value(); !bytes.Equal(v, []byte{1, 5, 6}) {
+		t.Errorf("server value is %v, want [1 5 6]", v)
+	}
+	opts := c.WriteOptions()
+	if len(opts) != 1 || opts[0]["type"].Value() != "command" || opts[0]["offset"].Value() != uint16(1) {
+		t.Errorf("server received write options %v", opts)
+	}
+	err = char.WriteValueWithOptions([]byte{7}, ble.WriteOptions{Type: ble.WriteReliable})
+	if !errors.Is(err, ble.ErrNotSupported) {
+		t.Errorf("reliable write returned %v, want %v", err, ble.ErrNotSupported)
+	}
+}
+
+func TestWriteLong(t *testing.T) {
+	_, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.MTU = 30
+	p.Services = []bletest.GattService{{
+		UUID: heartRateService,
+		Characteristics: []bletest.GattCharacteristic{
+			{UUID: bodySensorLocation, Flags: []string{"write", "write-without-response", "reliable-write"}},
+		},
+	}}
+	d := adapter.Advertise(p)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(bodySensorLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.MTU() != 30 {
+		t.Errorf("MTU() = %d, want 30", char.MTU())
+	}
+	data := make([]byte, 60)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = char.WriteLong(data, ble.LongWriteOptions{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.Characteristic(bodySensorLocation)
+	writes := c.Writes()
+	if len(writes) != 3 || len(writes[0]) != 27 || len(writes[2]) != 6 || !bytes.Equal(bytes.Join(writes, nil), data) {
+		t.Errorf("server received writes %v", writes)
+	}
+	for i, opts := range c.WriteOptions() {
+		if opts["type"].Value() != "request" {
+			t.Errorf("write type = %v, want request", opts["type"].Value())
+		}
+		if offset, _ := opts["offset"].Value().(uint16); offset != uint16(27*i) {
+			t.Errorf("write %d has offset %v, want %d", i, offset, 27*i)
+		}
+	}
+	if !bytes.Equal(c.Value(), data) {
+		t.Errorf("server value %v, want %v", c.Value(), data)
+	}
+	n, err := fmt.Fprintf(char.Writer(ble.LongWriteOptions{}), "%s", data[:30])
+	if err != nil || n != 30 {
+		t.Errorf("Fprintf returned %d, %v", n, err)
+	}
+	if len(c.Writes()) != 5 {
+		t.Errorf("server received %d writes, want 5", len(c.Writes()))
+	}
+	err = char.WriteLong(data, ble.LongWriteOptions{Type: ble.WriteReliable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes = c.Writes()[5:]
+	if len(writes) != 3 || len(writes[0]) != 25 || len(writes[2]) != 10 {
+		t.Errorf("server received reliable writes %v", writes)
+	}
+}
+
+func TestAmbiguousLookup(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.AddDevice(heartRateMonitor)
+	other := heartRateMonitor
+	other.Address = "aa:bb:cc:dd:ee:02"
+	adapter.AddDevice(other)
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.GetDeviceByUUID(heartRateService)
+	if !errors.Is(err, ble.ErrAmbiguous) {
+		t.Errorf("GetDeviceByUUID returned %v, want %v", err, ble.ErrAmbiguous)
+	}
+	_, err = conn.GetDeviceByName("nonexistent")
+	if !errors.Is(err, ble.ErrNotFound) {
+		t.Errorf("GetDeviceByName returned %v, want %v", err, ble.ErrNotFound)
+	}
+}
+
+func TestNotifyOrder(t *testing.T) {
+	server, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 200
+	var mu sync.Mutex
+	var received []byte
+	done := make(chan struct{})
+	err = char.HandleNotify(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, data[0])
+		if len(received) == n {
+			close(done)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := server.Adapter("hci0").Device("AA:BB:CC:DD:EE:01").Characteristic(heartRateMeasurement)
+	for i := 0; i < n; i++ {
+		if !c.Notify([]byte{byte(i)}) {
+			t.Fatal("notifications not enabled")
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for notifications")
+	}
+	for i, b := range received {
+		if b != byte(i) {
+			t.Fatalf("notification %d has value %d", i, b)
+		}
+	}
+	err = char.RemoveNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Notifying() {
+		t.Errorf("characteristic still notifying after RemoveNotify")
+	}
+}
+
+func TestSlowScanReceiver(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(testContext(t))
+	defer cancel()
+	// Do not receive from the scan channel until the end.
+	events, err := a.Scan(ctx, ble.DiscoveryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := adapter.Device("aa:bb:cc:dd:ee:01")
+	d.SetProperty("RSSI", int16(-40))
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 500
+	done := make(chan struct{})
+	count := 0
+	err = char.HandleNotifyWithOptions(testContext(t), func([]byte) {
+		count++
+		if count == n {
+			close(done)
+		}
+	}, ble.NotifyOptions{BufferSize: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := d.Characteristic(heartRateMeasurement)
+	for i := 0; i < n; i++ {
+		c.Notify([]byte{byte(i)})
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("notifications blocked by scan receiver")
+	}
+	if conn.SignalsDropped() == 0 {
+		t.Errorf("no signals dropped")
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestCloseWithNotifyHandler(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan struct{}, 3)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	handled := 0
+	err = char.HandleNotify(func([]byte) {
+		entered <- struct{}{}
+		<-release
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := adapter.Device("aa:bb:cc:dd:ee:01").Characteristic(heartRateMeasurement)
+	for i := 0; i < 3; i++ {
+		c.Notify([]byte{byte(i)})
+	}
+	<-entered
+	// Let the remaining notifications reach the queue.
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	close(release)
+	waitFor(t, func() bool {
+		buf := make([]byte, 1<<20)
+		return !bytes.Contains(buf[:runtime.Stack(buf, true)], []byte("(*notifyQueue).run"))
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if handled != 1 {
+		t.Errorf("handler called %d times after Close, want 1", handled)
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	server, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := adapter.Device("aa:bb:cc:dd:ee:01").Characteristic(heartRateMeasurement)
+	server.FailNext(c.Path(), "org.bluez.GattCharacteristic1.StartNotify", "org.bluez.Error.NotConnected")
+	received := make(chan []byte, 1)
+	handler := func(data []byte) { received <- data }
+	err = char.HandleNotify(handler)
+	if !errors.Is(err, ble.ErrNotConnected) {
+		t.Fatalf("HandleNotify returned %v, want ErrNotConnected", err)
+	}
+	err = char.HandleNotify(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Notify([]byte{72}) {
+		t.Fatal("notifications not enabled after retry")
+	}
+	select {
+	case data := <-received:
+		if !bytes.Equal(data, []byte{72}) {
+			t.Errorf("received %v", data)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for notification")
+	}
+	err = char.RemoveNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotificationsChannel(t *testing.T) {
+	server, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, err := char.Notifications(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := server.Adapter("hci0").Device("AA:BB:CC:DD:EE:01")
+	c := d.Characteristic(heartRateMeasurement)
+	for i := 0; i < 3; i++ {
+		c.Notify([]byte{byte(i)})
+	}
+	for i := 0; i < 3; i++ {
+		n := <-notifications
+		if n.Value[0] != byte(i) || n.Time.IsZero() {
+			t.Errorf("notification %d = %v", i, n)
+		}
+	}
+	d.Disconnect()
+	select {
+	case _, ok := <-notifications:
+		if ok {
+			t.Errorf("unexpected notification after disconnect")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("channel not closed after disconnect")
+	}
+}
+
+func TestAcquireFallback(t *testing.T) {
+	server, adapter, conn := setup(t)
+	p := heartRateMonitor
+	p.MTU = 100
+	p.Services = []bletest.GattService{{
+		UUID: heartRateService,
+		Characteristics: []bletest.GattCharacteristic{
+			{UUID: heartRateMeasurement, Flags: []string{"notify"}},
+			{UUID: bodySensorLocation, Flags: []string{"write-without-response"}},
+		},
+	}}
+	d := adapter.Advertise(p)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, mtu, err := char.AcquireNotify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu != 100 {
+		t.Errorf("AcquireNotify returned MTU %d, want 100", mtu)
+	}
+	c := d.Characteristic(heartRateMeasurement)
+	c.Notify([]byte{1, 2})
+	buf := make([]byte, mtu)
+	n, err := r.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], []byte{1, 2}) {
+		t.Errorf("Read returned %v, %v", buf[:n], err)
+	}
+	c.Notify([]byte{3, 4, 5})
+	n, err = r.Read(buf[:2])
+	if err != io.ErrShortBuffer || !bytes.Equal(buf[:n], []byte{3, 4}) {
+		t.Errorf("short Read returned %v, %v; want [3 4], %v", buf[:n], err, io.ErrShortBuffer)
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Notifying() {
+		t.Errorf("notifications still enabled after Close")
+	}
+	char, err = conn.GetCharacteristic(bodySensorLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.FailNext(char.Path(), "org.bluez.GattCharacteristic1.AcquireWrite", "org.bluez.Error.Failed")
+	_, _, err = char.AcquireWrite()
+	if !errors.Is(err, ble.ErrFailed) {
+		t.Errorf("AcquireWrite returned %v, want %v", err, ble.ErrFailed)
+	}
+	w, _, err := char.AcquireWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, err = w.Write([]byte{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := d.Characteristic(bodySensorLocation).WriteOptions()
+	if len(opts) != 1 || opts[0]["type"].Value() != "command" {
+		t.Errorf("server received write options %v", opts)
+	}
+}
+
+func TestFlagChecks(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	connectDevice(t, conn)
+	char, err := conn.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if char.GattFlags() != ble.FlagNotify {
+		t.Errorf("GattFlags() = %v, want notify", char.GattFlags())
+	}
+	_, err = char.ReadValue()
+	if !errors.Is(err, ble.ErrNotSupported) {
+		t.Errorf("ReadValue returned %v, want %v", err, ble.ErrNotSupported)
+	}
+	char, err = conn.GetCharacteristic(bodySensorLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = char.HandleNotify(func([]byte) {})
+	if !errors.Is(err, ble.ErrNotSupported) {
+		t.Errorf("HandleNotify returned %v, want %v", err, ble.ErrNotSupported)
+	}
+	err = char.WriteValueWithOptions([]byte{1}, ble.WriteOptions{Type: ble.WriteCommand})
+	if !errors.Is(err, ble.ErrNotSupported) {
+		t.Errorf("WriteValueWithOptions returned %v, want %v", err, ble.ErrNotSupported)
+	}
+	err = char.WriteValue([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplication(t *testing.T) {
+	_, adapter, conn := setup(t)
+	a, err := conn.GetAdapter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []byte
+	measurement := &ble.LocalCharacteristic{
+		UUID:  "2a37",
+		Flags: ble.FlagNotify,
+		Descriptors: []*ble.LocalDescriptor{
+			{UUID: "2901", Flags: ble.FlagRead, Value: []byte("heart rate")},
+		},
+	}
+	location := &ble.LocalCharacteristic{
+		UUID:  "2a38",
+		Flags: ble.FlagRead | ble.FlagWrite,
+		Value: []byte{1, 2, 3},
+		OnWrite: func(value []byte, opts ble.WriteOptions) error {
+			if len(value) == 0 {
+				return ble.ErrInvalidValueLength
+			}
+			written = value
+			return nil
+		},
+	}
+	err = measurement.Update([]byte{0, 60})
+	if err != nil || !bytes.Equal(measurement.Current(), []byte{0, 60}) {
+		t.Errorf("Update before registration returned %v, current value %v", err, measurement.Current())
+	}
+	app, err := a.RegisterApplication(&ble.LocalService{
+		UUID:            "180d",
+		Characteristics: []*ble.LocalCharacteristic{measurement, location},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.RegisterApplication(&ble.LocalService{UUID: "180f"})
+	if !errors.Is(err, ble.ErrAlreadyExists) {
+		t.Errorf("second RegisterApplication returned %v, want ErrAlreadyExists", err)
+	}
+	remote := adapter.Application()
+	if remote == nil || remote.Path() != ble.ApplicationPath || len(remote.Objects()) != 4 {
+		t.Fatalf("registered application %v", remote)
+	}
+	value, err := remote.ReadValue(bodySensorLocation, 1)
+	if err != nil || !bytes.Equal(value, []byte{2, 3}) {
+		t.Errorf("ReadValue returned %v, %v", value, err)
+	}
+	value, err = remote.ReadValue("00002901-0000-1000-8000-00805f9b34fb", 0)
+	if err != nil || string(value) != "heart rate" {
+		t.Errorf("ReadValue returned %q, %v", value, err)
+	}
+	err = remote.WriteValue(bodySensorLocation, []byte{4}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, []byte{4}) || !bytes.Equal(location.Current(), []byte{4}) {
+		t.Errorf("OnWrite received %v, current value %v", written, location.Current())
+	}
+	err = remote.WriteValue(bodySensorLocation, []byte{}, nil)
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.bluez.Error.InvalidValueLength" {
+		t.Errorf("WriteValue returned %v, want InvalidValueLength", err)
+	}
+	err = remote.StartNotify(bodySensorLocation)
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.bluez.Error.NotSupported" {
+		t.Errorf("StartNotify returned %v, want NotSupported", err)
+	}
+	err = remote.StartNotify(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !measurement.Notifying() {
+		t.Errorf("notifications not enabled")
+	}
+	err = measurement.Update([]byte{0, 72})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(remote.Notified(heartRateMeasurement)) == 1 })
+	if n := remote.Notified(heartRateMeasurement); !bytes.Equal(n[0], []byte{0, 72}) {
+		t.Errorf("notified %v", n)
+	}
+	err = app.Unregister()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.Application() != nil {
+		t.Errorf("application still registered")
+	}
+	if measurement.Notifying() {
+		t.Errorf("notifications still enabled after Unregister")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	_, adapter, conn := setup(t)
+	d := adapter.AddDevice(heartRateMonitor)
+	err := conn.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := conn.GetDeviceByAddress("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Disconnect()
+	err = device.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, device.Connected)
+	d.Disconnect()
+	waitFor(t, func() bool { return !device.Connected() })
+	adapter.AddDevice(bletest.Peripheral{Address: "aa:bb:cc:dd:ee:02", Name: "other"})
+	waitFor(t, func() bool {
+		_, err := conn.GetDeviceByName("other")
+		return err == nil
+	})
+	err = conn.Adapters()[0].RemoveDevice(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, err := conn.GetDeviceByName("HRM")
+		return errors.Is(err, ble.ErrNotFound)
+	})
+}
+
+func TestMultipleAdapters(t *testing.T) {
+	server, hci0, conn := setup(t)
+	hci1 := server.AddAdapter("hci1", "00:11:22:33:44:66")
+	hci0.AddDevice(heartRateMonitor)
+	hci1.AddDevice(heartRateMonitor)
+	err := conn.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.Adapters()) != 2 {
+		t.Fatalf("found %d adapters, want 2", len(conn.Adapters()))
+	}
+	_, err = conn.GetAdapter()
+	if !errors.Is(err, ble.ErrAmbiguous) {
+		t.Errorf("GetAdapter returned %v, want %v", err, ble.ErrAmbiguous)
+	}
+	adapter, err := conn.GetAdapterByName("hci1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byAddress, err := conn.GetAdapterByAddress("00:11:22:33:44:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.Path() != byAddress.Path() {
+		t.Errorf("GetAdapterByAddress returned %s, want %s", byAddress.Path(), adapter.Path())
+	}
+	device, err := adapter.GetDeviceByAddress("aa:bb:cc:dd:ee:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%s/dev_AA_BB_CC_DD_EE_01", adapter.Path())
+	if string(device.Path()) != want {
+		t.Errorf("device path is %s, want %s", device.Path(), want)
+	}
+}
+
+func TestGattTree(t *testing.T) {
+	_, adapter, conn := setup(t)
+	adapter.Advertise(heartRateMonitor)
+	device := connectDevice(t, conn)
+	services := device.Services()
+	if len(services) != 1 || services[0].UUID() != heartRateService || !services[0].Primary() {
+		t.Fatalf("unexpected services %v", services)
+	}
+	chars := services[0].Characteristics()
+	if len(chars) != 2 || chars[0].UUID() != heartRateMeasurement || chars[1].UUID() != bodySensorLocation {
+		t.Fatalf("unexpected characteristics %v", chars)
+	}
+	if chars[0].Handle() >= chars[1].Handle() {
+		t.Errorf("characteristics not in handle order")
+	}
+	descs := chars[0].Descriptors()
+	if len(descs) != 1 || descs[0].UUID() != clientConfiguration {
+		t.Fatalf("unexpected descriptors %v", descs)
+	}
+	service, err := device.GetService(heartRateService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	char, err := service.GetCharacteristic(heartRateMeasurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = char.GetDescriptor(clientConfiguration)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,10 +1,13 @@
 package ble
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+
+	"github.com/godbus/dbus"
 )
 
 const (
@@ -14,6 +17,26 @@ const (
 
 // The Device type corresponds to the org.bluez.Device1 interface.
 // See bluez/doc/devicet-api.txt
+//
+// The Context variants of Connect, Disconnect, and Pair use the given context
+// to bound the operation, instead of the default call timeout.
+//
+// GetService finds a service of the device with the given UUID.
+// Unlike the GetService method of the Connection type, it can be used
+// when more than one connected device provides the same service.
+//
+// Services returns the services of the device, in handle order.
+//
+// ConnectProfile and DisconnectProfile connect and disconnect
+// the profile with the given UUID.
+// CancelPairing cancels a pairing operation that is in progress.
+//
+// The Set methods change the device's properties.
+//
+// RSSI and TxPower also return whether the value is available;
+// BlueZ only provides them for devices seen during discovery.
+// The other advertisement accessors return zero values
+// for properties that BlueZ does not provide.
 type Device interface {
 	BaseObject
 
@@ -23,13 +46,51 @@ type Device interface {
 	Connected() bool
 	Paired() bool
 
+	Alias() string
+	Icon() string
+	Appearance() uint16
+	Class() uint32
+	Trusted() bool
+	Blocked() bool
+	ServicesResolved() bool
+
+	RSSI() (int16, bool)
+	TxPower() (int16, bool)
+	ManufacturerData() map[uint16][]byte
+	ServiceData() map[string][]byte
+	AdvertisingFlags() []byte
+	AdvertisingData() map[byte][]byte
+
+	WakeAllowed() bool
+
+	SetTrusted(bool) error
+	SetBlocked(bool) error
+	SetAlias(string) error
+	SetWakeAllowed(bool) error
+
 	Connect() error
 	Disconnect() error
 	Pair() error
+	CancelPairing() error
+	ConnectProfile(uuid string) error
+	DisconnectProfile(uuid string) error
+
+	ConnectContext(context.Context) error
+	DisconnectContext(context.Context) error
+	PairContext(context.Context) error
+	CancelPairingContext(context.Context) error
+	ConnectProfileContext(ctx context.Context, uuid string) error
+	DisconnectProfileContext(ctx context.Context, uuid string) error
+
+	GetService(uuid string) (Service, error)
+	Services() []Service
 }
 
-func (conn *Connection) matchDevice(matching predicate) (Device, error) {
-	return conn.findObject(deviceInterface, matching)
+// matchDevice finds a device below the given root (if nonempty) satisfying the given predicate.
+func (conn *Connection) matchDevice(root dbus.ObjectPath, matching predicate) (Device, error) {
+	return conn.findObject(deviceInterface, func(device *blob) bool {
+		return inTree(device.path, root) && matching(device)
+	})
 }
 
 // ValidAddress checks whether addr is a valid MAC address.
@@ -40,8 +101,12 @@ func ValidAddress(addr string) bool {
 
 // GetDeviceByAddress finds a Device in the object cache with the given address.
 func (conn *Connection) GetDeviceByAddress(address Address) (Device, error) {
+	return conn.getDeviceByAddress("", address)
+}
+
+func (conn *Connection) getDeviceByAddress(root dbus.ObjectPath, address Address) (Device, error) {
 	addr := Address(strings.ToUpper(string(address)))
-	device, err := conn.matchDevice(func(device *blob) bool {
+	device, err := conn.matchDevice(root, func(device *blob) bool {
 		return device.Address() == addr
 	})
 	if err != nil {
@@ -52,7 +117,11 @@ func (conn *Connection) GetDeviceByAddress(address Address) (Device, error) {
 
 // GetDeviceByName finds a Device in the object cache with the given name.
 func (conn *Connection) GetDeviceByName(name string) (Device, error) {
-	device, err := conn.matchDevice(func(device *blob) bool {
+	return conn.getDeviceByName("", name)
+}
+
+func (conn *Connection) getDeviceByName(root dbus.ObjectPath, name string) (Device, error) {
+	device, err := conn.matchDevice(root, func(device *blob) bool {
 		return device.Name() == name
 	})
 	if err != nil {
@@ -63,7 +132,11 @@ func (conn *Connection) GetDeviceByName(name string) (Device, error) {
 
 // GetDeviceByUUID finds a Device in the object cache matching the given UUIDs.
 func (conn *Connection) GetDeviceByUUID(uuids ...string) (Device, error) {
-	device, err := conn.matchDevice(func(device *blob) bool {
+	return conn.getDeviceByUUID("", uuids...)
+}
+
+func (conn *Connection) getDeviceByUUID(root dbus.ObjectPath, uuids ...string) (Device, error) {
+	device, err := conn.matchDevice(root, func(device *blob) bool {
 		return UUIDsInclude(device.UUIDs(), uuids)
 	})
 	if err != nil {
@@ -86,39 +159,223 @@ func UUIDsInclude(advertised []string, uuids []string) bool {
 	return true
 }
 
+// devicePath returns the path of the device that the object
+// with the given path belongs to, or the path itself if it does not
+// belong to a device.
+// For example, the characteristic /org/bluez/hci0/dev_XX/service000a/char000b
+// belongs to the device /org/bluez/hci0/dev_XX.
+func devicePath(path dbus.ObjectPath) dbus.ObjectPath {
+	elems := strings.Split(string(path), "/")
+	for i, elem := range elems {
+		if strings.HasPrefix(elem, "dev_") {
+			return dbus.ObjectPath(strings.Join(elems[:i+1], "/"))
+		}
+	}
+	return path
+}
+
 func (device *blob) Address() Address {
-	return Address(device.properties["Address"].Value().(string))
+	return Address(device.property("Address").Value().(string))
 }
 
+// AddressType returns "public" or "random",
+// or "" if BlueZ does not provide it (as older versions do not for adapters).
 func (device *blob) AddressType() string {
-	return device.properties["AddressType"].Value().(string)
+	t, _ := device.property("AddressType").Value().(string)
+	return t
 }
 
 func (device *blob) UUIDs() []string {
-	return device.properties["UUIDs"].Value().([]string)
+	uuids, _ := device.property("UUIDs").Value().([]string)
+	return uuids
 }
 
 func (device *blob) Connected() bool {
-	return device.properties["Connected"].Value().(bool)
+	return device.property("Connected").Value().(bool)
 }
 
 func (device *blob) Paired() bool {
-	return device.properties["Paired"].Value().(bool)
+	return device.property("Paired").Value().(bool)
+}
+
+func (device *blob) Alias() string {
+	alias, _ := device.property("Alias").Value().(string)
+	return alias
+}
+
+func (device *blob) Icon() string {
+	icon, _ := device.property("Icon").Value().(string)
+	return icon
+}
+
+func (device *blob) Appearance() uint16 {
+	appearance, _ := device.property("Appearance").Value().(uint16)
+	return appearance
+}
+
+func (device *blob) Class() uint32 {
+	class, _ := device.property("Class").Value().(uint32)
+	return class
+}
+
+func (device *blob) Trusted() bool {
+	trusted, _ := device.property("Trusted").Value().(bool)
+	return trusted
+}
+
+func (device *blob) Blocked() bool {
+	blocked, _ := device.property("Blocked").Value().(bool)
+	return blocked
+}
+
+func (device *blob) ServicesResolved() bool {
+	resolved, _ := device.property("ServicesResolved").Value().(bool)
+	return resolved
+}
+
+func (device *blob) WakeAllowed() bool {
+	allowed, _ := device.property("WakeAllowed").Value().(bool)
+	return allowed
+}
+
+func (device *blob) SetTrusted(trusted bool) error {
+	return device.setProperty("Trusted", trusted)
+}
+
+func (device *blob) SetBlocked(blocked bool) error {
+	return device.setProperty("Blocked", blocked)
+}
+
+func (device *blob) SetWakeAllowed(allowed bool) error {
+	return device.setProperty("WakeAllowed", allowed)
+}
+
+func (device *blob) RSSI() (int16, bool) {
+	rssi, ok := device.property("RSSI").Value().(int16)
+	return rssi, ok
+}
+
+func (device *blob) TxPower() (int16, bool) {
+	power, ok := device.property("TxPower").Value().(int16)
+	return power, ok
+}
+
+// ManufacturerData returns the device's manufacturer-specific advertisement data,
+// keyed by company identifier.
+func (device *blob) ManufacturerData() map[uint16][]byte {
+	dict, _ := device.property("ManufacturerData").Value().(map[uint16]dbus.Variant)
+	if dict == nil {
+		return nil
+	}
+	data := make(map[uint16][]byte, len(dict))
+	for k, v := range dict {
+		data[k], _ = v.Value().([]byte)
+	}
+	return data
+}
+
+// ServiceData returns the device's service advertisement data, keyed by UUID.
+func (device *blob) ServiceData() map[string][]byte {
+	dict, _ := device.property("ServiceData").Value().(map[string]dbus.Variant)
+	if dict == nil {
+		return nil
+	}
+	data := make(map[string][]byte, len(dict))
+	for k, v := range dict {
+		data[k], _ = v.Value().([]byte)
+	}
+	return data
+}
+
+func (device *blob) AdvertisingFlags() []byte {
+	flags, _ := device.property("AdvertisingFlags").Value().([]byte)
+	return flags
+}
+
+// AdvertisingData returns the device's raw advertisement data,
+// keyed by AD type.
+func (device *blob) AdvertisingData() map[byte][]byte {
+	dict, _ := device.property("AdvertisingData").Value().(map[byte]dbus.Variant)
+	if dict == nil {
+		return nil
+	}
+	data := make(map[byte][]byte, len(dict))
+	for k, v := range dict {
+		data[k], _ = v.Value().([]byte)
+	}
+	return data
 }
 
 func (device *blob) Connect() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.ConnectContext(ctx)
+}
+
+func (device *blob) ConnectContext(ctx context.Context) error {
 	log.Printf("%s: connecting", device.Name())
-	return device.call("Connect")
+	return device.callContext(ctx, "Connect")
 }
 
 func (device *blob) Disconnect() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.DisconnectContext(ctx)
+}
+
+func (device *blob) DisconnectContext(ctx context.Context) error {
 	log.Printf("%s: disconnecting", device.Name())
-	return device.call("Disconnect")
+	return device.callContext(ctx, "Disconnect")
 }
 
 func (device *blob) Pair() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.PairContext(ctx)
+}
+
+func (device *blob) PairContext(ctx context.Context) error {
 	log.Printf("%s: pairing", device.Name())
-	return device.call("Pair")
+	return device.callContext(ctx, "Pair")
+}
+
+func (device *blob) CancelPairing() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.CancelPairingContext(ctx)
+}
+
+func (device *blob) CancelPairingContext(ctx context.Context) error {
+	log.Printf("%s: canceling pairing", device.Name())
+	return device.callContext(ctx, "CancelPairing")
+}
+
+func (device *blob) ConnectProfile(uuid string) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.ConnectProfileContext(ctx, uuid)
+}
+
+func (device *blob) ConnectProfileContext(ctx context.Context, uuid string) error {
+	if !ValidUUID(uuid) {
+		return fmt.Errorf("%w: invalid UUID %q", ErrInvalidArguments, uuid)
+	}
+	log.Printf("%s: connecting profile %s", device.Name(), ShortUUID(uuid))
+	return device.callContext(ctx, "ConnectProfile", LongUUID(uuid))
+}
+
+func (device *blob) DisconnectProfile(uuid string) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return device.DisconnectProfileContext(ctx, uuid)
+}
+
+func (device *blob) DisconnectProfileContext(ctx context.Context, uuid string) error {
+	if !ValidUUID(uuid) {
+		return fmt.Errorf("%w: invalid UUID %q", ErrInvalidArguments, uuid)
+	}
+	log.Printf("%s: disconnecting profile %s", device.Name(), ShortUUID(uuid))
+	return device.callContext(ctx, "DisconnectProfile", LongUUID(uuid))
 }
 
 func stringsContain(a []string, str string) bool {
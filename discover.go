@@ -1,27 +1,30 @@
 package ble
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/godbus/dbus"
 )
 
-func (conn *Connection) addMatch(rule string) error {
-	return conn.bus.BusObject().Call(
-		"org.freedesktop.DBus.AddMatch",
-		0,
-		rule,
-	).Err
+func (conn *Connection) addMatch(ctx context.Context, rule string) error {
+	return conn.backend.AddMatch(ctx, rule)
 }
 
-func (conn *Connection) removeMatch(rule string) error {
-	return conn.bus.BusObject().Call(
-		"org.freedesktop.DBus.RemoveMatch",
-		0,
-		rule,
-	).Err
+func (conn *Connection) removeMatch(ctx context.Context, rule string) error {
+	return conn.backend.RemoveMatch(ctx, rule)
+}
+
+// removeMatchRule removes a match rule during cleanup,
+// when the caller's context may already be done.
+func (conn *Connection) removeMatchRule(rule string) {
+	ctx, cancel := callContext()
+	defer cancel()
+	_ = conn.removeMatch(ctx, rule)
 }
 
 // DiscoveryTimeoutError indicates that discovery has timed out.
@@ -31,112 +34,194 @@ func (e DiscoveryTimeoutError) Error() string {
 	return fmt.Sprintf("discovery timeout %v", []string(e))
 }
 
+// Is allows a DiscoveryTimeoutError to match ErrTimeout.
+func (e DiscoveryTimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
 // Discover puts the adapter in discovery mode,
 // waits for the specified timeout to discover one of the given UUIDs,
 // and then stops discovery mode.
 func (adapter *blob) Discover(timeout time.Duration, uuids ...string) error {
-	conn := adapter.conn
-	signals := make(chan *dbus.Signal)
-	defer close(signals)
-	conn.bus.Signal(signals)
-	defer conn.bus.RemoveSignal(signals)
-	rule := "type='signal',interface='org.freedesktop.DBus.ObjectManager',member='InterfacesAdded'"
-	err := adapter.conn.addMatch(rule)
-	if err != nil {
-		return err
-	}
-	defer conn.removeMatch(rule)
-	err = adapter.SetDiscoveryFilter(uuids...)
-	if err != nil {
-		return err
-	}
-	err = adapter.StartDiscovery()
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	return adapter.DiscoverContext(ctx, uuids...)
+}
+
+// DiscoverContext puts the adapter in discovery mode,
+// waits until a device advertising all of the given UUIDs is discovered
+// or the context is done, and then stops discovery mode.
+// If the context's deadline is exceeded, a DiscoveryTimeoutError is returned.
+func (adapter *blob) DiscoverContext(ctx context.Context, uuids ...string) error {
+	_, err := adapter.DiscoverMatching(ctx, DiscoveryFilter{UUIDs: uuids}, MatchUUIDs(uuids...))
+	return err
+}
+
+// DiscoverMatching puts the adapter in discovery mode with the given filter,
+// waits until a device satisfying the matcher is found or updated
+// or the context is done, and then stops discovery mode.
+// If the context's deadline is exceeded, a DiscoveryTimeoutError is returned.
+// If the connection is closed first, the error satisfies errors.Is(err, ErrNotReady).
+func (adapter *blob) DiscoverMatching(ctx context.Context, filter DiscoveryFilter, match Matcher) (Device, error) {
+	scanCtx, cancel := context.WithCancel(ctx)
+	events, err := adapter.Scan(scanCtx, filter)
 	if err != nil {
-		return err
-	}
-	defer adapter.StopDiscovery()
-	var t <-chan time.Time
-	if timeout != 0 {
-		t = time.After(timeout)
+		cancel()
+		return nil, err
 	}
-	return adapter.discoverLoop(uuids, signals, t)
-}
-
-func (adapter *blob) discoverLoop(uuids []string, signals <-chan *dbus.Signal, timeout <-chan time.Time) error {
-	for {
-		select {
-		case s := <-signals:
-			switch s.Name {
-			case interfacesAdded:
-				if adapter.discoveryComplete(s, uuids) {
-					return nil
-				}
-			default:
-				log.Printf("%s: unexpected signal %s", adapter.Name(), s.Name)
-			}
-		case <-timeout:
-			return DiscoveryTimeoutError(uuids)
+	defer func() {
+		// Wait until discovery mode has been stopped.
+		cancel()
+		for range events {
+		}
+	}()
+	for e := range events {
+		if e.Type == DeviceLost || (match != nil && !match(e.Device)) {
+			continue
 		}
+		log.Printf("%s: discovered %s (%s)", adapter.Name(), e.Device.Address(), e.Device.Name())
+		return e.Device, nil
+	}
+	err = ctx.Err()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, DiscoveryTimeoutError(filter.UUIDs)
+	case err != nil:
+		return nil, err
+	default:
+		return nil, errScanClosed
 	}
 }
 
-func (adapter *blob) discoveryComplete(s *dbus.Signal, uuids []string) bool {
-	props := interfaceProperties(s)
-	if props == nil {
-		log.Printf("%s: skipping signal %s with no device interface", adapter.Name(), s.Name)
-		return false
-	}
-	addr, ok := props["Address"].Value().(string)
-	if !ok {
-		addr = "[unknown address]"
+// errScanClosed is returned when a scan ends because the connection was closed.
+var errScanClosed = fmt.Errorf("%w: scan ended because the connection was closed", ErrNotReady)
+
+// DiscoverAll puts the adapter in discovery mode with the given filter
+// and collects the devices satisfying the matcher until the context is done
+// or limit devices (if limit > 0) have been found.
+// Discovery mode is then stopped and the devices are returned
+// in order of decreasing RSSI.
+// The context's deadline is the discovery window, so reaching it
+// is not an error.  If the connection is closed first, the devices
+// found so far are returned together with an error satisfying
+// errors.Is(err, ErrNotReady).
+func (adapter *blob) DiscoverAll(ctx context.Context, filter DiscoveryFilter, match Matcher, limit int) ([]Device, error) {
+	scanCtx, cancel := context.WithCancel(ctx)
+	events, err := adapter.Scan(scanCtx, filter)
+	if err != nil {
+		cancel()
+		return nil, err
 	}
-	name, ok := props["Name"].Value().(string)
-	if !ok {
-		name = "[unknown name]"
+	defer func() {
+		// Wait until discovery mode has been stopped.
+		cancel()
+		for range events {
+		}
+	}()
+	found := make(map[dbus.ObjectPath]Device)
+	for e := range events {
+		path := e.Device.Path()
+		if e.Type == DeviceLost {
+			delete(found, path)
+			continue
+		}
+		if match != nil && !match(e.Device) {
+			continue
+		}
+		found[path] = e.Device
+		if limit > 0 && len(found) >= limit {
+			break
+		}
 	}
-	services, ok := props["UUIDs"].Value().([]string)
-	if !ok {
-		services = nil
+	devices := make([]Device, 0, len(found))
+	for _, device := range found {
+		devices = append(devices, device)
 	}
-	if !UUIDsInclude(services, uuids) {
-		log.Printf("%s: skipping signal for %s (%s) without matching UUIDs", adapter.Name(), addr, name)
-		log.Printf("%s: wanted %v, got %v", adapter.Name(), uuids, services)
-		return false
+	sortByRSSI(devices)
+	if ctx.Err() == nil && (limit <= 0 || len(devices) < limit) {
+		return devices, errScanClosed
 	}
-	log.Printf("%s: discovered %s (%s)", adapter.Name(), addr, name)
-	return true
+	return devices, nil
 }
 
-// If the InterfacesAdded signal contains deviceInterface,
-// return the corresponding properties, otherwise nil.
-// See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-objectmanager
-func interfaceProperties(s *dbus.Signal) Properties {
-	var dict map[string]Properties
-	err := dbus.Store(s.Body[1:2], &dict)
+// DiscoverAll is like the DiscoverAll method of the Adapter type,
+// using the default adapter.
+func (conn *Connection) DiscoverAll(ctx context.Context, filter DiscoveryFilter, match Matcher, limit int) ([]Device, error) {
+	adapter, err := conn.GetAdapter()
 	if err != nil {
-		log.Print(err)
-		return nil
+		return nil, err
 	}
-	return dict[deviceInterface]
+	return adapter.DiscoverAll(ctx, filter, match, limit)
+}
+
+// sortByRSSI sorts devices in order of decreasing RSSI,
+// followed by those without an RSSI value in path order.
+func sortByRSSI(devices []Device) {
+	sort.Slice(devices, func(i, j int) bool {
+		ri, iok := devices[i].RSSI()
+		rj, jok := devices[j].RSSI()
+		switch {
+		case iok && jok && ri != rj:
+			return ri > rj
+		case iok != jok:
+			return iok
+		default:
+			return devices[i].Path() < devices[j].Path()
+		}
+	})
+}
+
+// timeoutContext returns a context with the given timeout,
+// or with no deadline if timeout is 0.
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
 }
 
 // Discover initiates discovery for a LE peripheral with the given address (if nonempty), advertising the given UUIDs.
 // It waits for at most the specified timeout, or indefinitely if timeout = 0.
 func (conn *Connection) Discover(timeout time.Duration, address Address, uuids ...string) (Device, error) {
+	ctx, cancel := timeoutContext(timeout)
+	defer cancel()
+	return conn.DiscoverContext(ctx, address, uuids...)
+}
+
+// DiscoverContext is like Discover but waits until the context is done
+// instead of for a fixed timeout.
+// The default adapter is used; see also the DiscoverDeviceContext method of the Adapter type.
+func (conn *Connection) DiscoverContext(ctx context.Context, address Address, uuids ...string) (Device, error) {
 	adapter, err := conn.GetAdapter()
 	if err != nil {
 		return nil, err
 	}
-	err = adapter.Discover(timeout, uuids...)
-	if err != nil {
-		return nil, err
-	}
-	err = conn.Update()
+	return adapter.DiscoverDeviceContext(ctx, address, uuids...)
+}
+
+// DiscoverMatching is like the DiscoverMatching method of the Adapter type,
+// using the default adapter.
+func (conn *Connection) DiscoverMatching(ctx context.Context, filter DiscoveryFilter, match Matcher) (Device, error) {
+	adapter, err := conn.GetAdapter()
 	if err != nil {
 		return nil, err
 	}
+	return adapter.DiscoverMatching(ctx, filter, match)
+}
+
+// DiscoverDevice performs DiscoverDeviceContext with context.Background(),
+// so it waits until a matching device is found.
+func (adapter *blob) DiscoverDevice(address Address, uuids ...string) (Device, error) {
+	return adapter.DiscoverDeviceContext(context.Background(), address, uuids...)
+}
+
+// DiscoverDeviceContext initiates discovery on the adapter for a LE peripheral
+// with the given address (if nonempty), advertising the given UUIDs.
+// It waits until the context is done.
+func (adapter *blob) DiscoverDeviceContext(ctx context.Context, address Address, uuids ...string) (Device, error) {
+	match := MatchUUIDs(uuids...)
 	if address != "" {
-		return conn.GetDeviceByAddress(address)
+		match = MatchAll(MatchAddress(address), match)
 	}
-	return conn.GetDeviceByUUID(uuids...)
+	return adapter.DiscoverMatching(ctx, DiscoveryFilter{UUIDs: uuids}, match)
 }
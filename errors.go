@@ -0,0 +1,179 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+// Errors returned by BlueZ are mapped to these values,
+// so they can be tested with errors.Is.
+// See bluez/doc/*-api.txt for the conditions under which they occur.
+var (
+	ErrAlreadyConnected        = errors.New("already connected")
+	ErrAlreadyExists           = errors.New("already exists")
+	ErrAuthenticationCanceled  = errors.New("authentication canceled")
+	ErrAuthenticationFailed    = errors.New("authentication failed")
+	ErrAuthenticationRejected  = errors.New("authentication rejected")
+	ErrAuthenticationTimeout   = errors.New("authentication timeout")
+	ErrCanceled                = errors.New("canceled")
+	ErrConnectionAttemptFailed = errors.New("connection attempt failed")
+	ErrDoesNotExist            = errors.New("does not exist")
+	ErrFailed                  = errors.New("operation failed")
+	ErrInProgress              = errors.New("operation in progress")
+	ErrInvalidArguments        = errors.New("invalid arguments")
+	ErrInvalidOffset           = errors.New("invalid offset")
+	ErrInvalidValueLength      = errors.New("invalid value length")
+	ErrNotAuthorized           = errors.New("not authorized")
+	ErrNotAvailable            = errors.New("not available")
+	ErrNotConnected            = errors.New("not connected")
+	ErrNotPermitted            = errors.New("not permitted")
+	ErrNotReady                = errors.New("not ready")
+	ErrNotSupported            = errors.New("not supported")
+	ErrRejected                = errors.New("rejected")
+
+	// ErrTimeout indicates that an operation did not complete in time,
+	// either because of a D-Bus timeout or a context deadline.
+	ErrTimeout = errors.New("timeout")
+
+	// ErrNotFound indicates that no object in the cache matched a lookup.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAmbiguous indicates that more than one object in the cache matched a lookup.
+	ErrAmbiguous = errors.New("ambiguous")
+)
+
+var errorNames = map[string]error{
+	"org.bluez.Error.AlreadyConnected":        ErrAlreadyConnected,
+	"org.bluez.Error.AlreadyExists":           ErrAlreadyExists,
+	"org.bluez.Error.AuthenticationCanceled":  ErrAuthenticationCanceled,
+	"org.bluez.Error.AuthenticationFailed":    ErrAuthenticationFailed,
+	"org.bluez.Error.AuthenticationRejected":  ErrAuthenticationRejected,
+	"org.bluez.Error.AuthenticationTimeout":   ErrAuthenticationTimeout,
+	"org.bluez.Error.Canceled":                ErrCanceled,
+	"org.bluez.Error.ConnectionAttemptFailed": ErrConnectionAttemptFailed,
+	"org.bluez.Error.DoesNotExist":            ErrDoesNotExist,
+	"org.bluez.Error.Failed":                  ErrFailed,
+	"org.bluez.Error.InProgress":              ErrInProgress,
+	"org.bluez.Error.InvalidArguments":        ErrInvalidArguments,
+	"org.bluez.Error.InvalidOffset":           ErrInvalidOffset,
+	"org.bluez.Error.InvalidValueLength":      ErrInvalidValueLength,
+	"org.bluez.Error.NotAuthorized":           ErrNotAuthorized,
+	"org.bluez.Error.NotAvailable":            ErrNotAvailable,
+	"org.bluez.Error.NotConnected":            ErrNotConnected,
+	"org.bluez.Error.NotPermitted":            ErrNotPermitted,
+	"org.bluez.Error.NotReady":                ErrNotReady,
+	"org.bluez.Error.NotSupported":            ErrNotSupported,
+	"org.bluez.Error.Rejected":                ErrRejected,
+
+	"org.freedesktop.DBus.Error.InvalidArgs":      ErrInvalidArguments,
+	"org.freedesktop.DBus.Error.PropertyReadOnly": ErrNotPermitted,
+	"org.freedesktop.DBus.Error.NoReply":          ErrTimeout,
+	"org.freedesktop.DBus.Error.Timeout":          ErrTimeout,
+	"org.freedesktop.DBus.Error.UnknownObject":    ErrDoesNotExist,
+	"org.freedesktop.DBus.Error.ServiceUnknown":   ErrNotAvailable,
+}
+
+// Error represents an error returned by a D-Bus method call.
+// It wraps the corresponding sentinel value (such as ErrNotConnected),
+// if there is one.
+type Error struct {
+	Name    string
+	Message string
+
+	err error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Unwrap returns the sentinel value corresponding to the D-Bus error name,
+// or nil if there is none.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// mapError converts D-Bus errors to Error values.
+// Other errors are returned unchanged.
+func mapError(err error) error {
+	var e dbus.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	msg := ""
+	if len(e.Body) != 0 {
+		msg, _ = e.Body[0].(string)
+	}
+	return &Error{Name: e.Name, Message: msg, err: errorNames[e.Name]}
+}
+
+// timeoutError wraps a context error so that it also matches ErrTimeout.
+type timeoutError struct {
+	err error
+}
+
+func (e timeoutError) Error() string {
+	return "BLE call timeout: " + e.err.Error()
+}
+
+func (e timeoutError) Unwrap() error {
+	return e.err
+}
+
+func (e timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// contextError returns the error for a context that is done.
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return timeoutError{err}
+	}
+	return err
+}
+
+// lookupError indicates that the number of objects matching a lookup
+// in the object cache was not exactly one.
+type lookupError struct {
+	iface string
+	count int
+}
+
+func (e lookupError) Error() string {
+	if e.count == 0 {
+		return fmt.Sprintf("cannot find %s", e.iface)
+	}
+	return fmt.Sprintf("found %d instances of %s", e.count, e.iface)
+}
+
+func (e lookupError) Is(target error) bool {
+	if e.count == 0 {
+		return target == ErrNotFound
+	}
+	return target == ErrAmbiguous
+}
+
+// Temporary reports whether err indicates a failure that may succeed
+// if the operation is retried, such as a timeout or an operation
+// already in progress.
+func Temporary(err error) bool {
+	for _, e := range []error{
+		ErrTimeout,
+		ErrInProgress,
+		ErrNotReady,
+		ErrConnectionAttemptFailed,
+		ErrAuthenticationTimeout,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
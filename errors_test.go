@@ -0,0 +1,67 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestMapError(t *testing.T) {
+	cases := []struct {
+		err       error
+		sentinel  error
+		message   string
+		temporary bool
+	}{
+		{dbus.Error{Name: "org.bluez.Error.NotConnected", Body: []interface{}{"Not Connected"}}, ErrNotConnected, "org.bluez.Error.NotConnected: Not Connected", false},
+		{dbus.Error{Name: "org.bluez.Error.InProgress", Body: []interface{}{"In Progress"}}, ErrInProgress, "org.bluez.Error.InProgress: In Progress", true},
+		{dbus.Error{Name: "org.bluez.Error.AuthenticationFailed"}, ErrAuthenticationFailed, "org.bluez.Error.AuthenticationFailed", false},
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.NoReply"}, ErrTimeout, "org.freedesktop.DBus.Error.NoReply", true},
+		{dbus.Error{Name: "com.example.Error.Unknown"}, nil, "com.example.Error.Unknown", false},
+	}
+	for _, c := range cases {
+		t.Run(c.message, func(t *testing.T) {
+			err := mapError(c.err)
+			if err.Error() != c.message {
+				t.Errorf("mapError(%v) == %q, want %q", c.err, err.Error(), c.message)
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("mapError(%v) is not an *Error", c.err)
+			}
+			if c.sentinel != nil && !errors.Is(err, c.sentinel) {
+				t.Errorf("mapError(%v) does not match %v", c.err, c.sentinel)
+			}
+			if Temporary(err) != c.temporary {
+				t.Errorf("Temporary(%v) == %v, want %v", err, !c.temporary, c.temporary)
+			}
+		})
+	}
+}
+
+func TestLookupError(t *testing.T) {
+	err := fmt.Errorf("%w with name %q", lookupError{iface: deviceInterface}, "foo")
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrAmbiguous) {
+		t.Errorf("%v should match only ErrNotFound", err)
+	}
+	err = lookupError{iface: deviceInterface, count: 2}
+	if !errors.Is(err, ErrAmbiguous) || errors.Is(err, ErrNotFound) {
+		t.Errorf("%v should match only ErrAmbiguous", err)
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	err := contextError(ctx)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%v should match ErrTimeout and context.DeadlineExceeded", err)
+	}
+	if !errors.Is(DiscoveryTimeoutError(nil), ErrTimeout) {
+		t.Errorf("DiscoveryTimeoutError should match ErrTimeout")
+	}
+}
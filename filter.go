@@ -0,0 +1,157 @@
+package ble
+
+import (
+	"context"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// DiscoveryFilter corresponds to the argument of the
+// org.bluez.Adapter1.SetDiscoveryFilter method.
+// See bluez/doc/adapter-api.txt
+//
+// Zero values leave the corresponding BlueZ default in effect,
+// except that Transport defaults to "le".
+type DiscoveryFilter struct {
+	// UUIDs restricts discovery to devices advertising any of these UUIDs.
+	UUIDs []string
+
+	// RSSI restricts discovery to devices with at least this signal strength.
+	RSSI int16
+
+	// Pathloss restricts discovery to devices with at most this path loss
+	// (the difference between their TxPower and RSSI).
+	Pathloss uint16
+
+	// Transport is "auto", "bredr", or "le".
+	Transport string
+
+	// FilterDuplicates suppresses signals for advertisements
+	// that have the same data as the previous one
+	// (that is, it sets the DuplicateData key to false).
+	FilterDuplicates bool
+
+	// Discoverable restricts discovery to devices in discoverable mode.
+	Discoverable bool
+
+	// Pattern restricts discovery to devices whose address or name
+	// begins with this prefix.
+	Pattern string
+}
+
+// properties returns the dictionary passed to SetDiscoveryFilter.
+func (f DiscoveryFilter) properties() Properties {
+	transport := f.Transport
+	if transport == "" {
+		transport = "le"
+	}
+	uuids := f.UUIDs
+	if uuids == nil {
+		uuids = []string{}
+	}
+	props := Properties{
+		"Transport": dbus.MakeVariant(transport),
+		"UUIDs":     dbus.MakeVariant(uuids),
+	}
+	if f.RSSI != 0 {
+		props["RSSI"] = dbus.MakeVariant(f.RSSI)
+	}
+	if f.Pathloss != 0 {
+		props["Pathloss"] = dbus.MakeVariant(f.Pathloss)
+	}
+	if f.FilterDuplicates {
+		props["DuplicateData"] = dbus.MakeVariant(false)
+	}
+	if f.Discoverable {
+		props["Discoverable"] = dbus.MakeVariant(true)
+	}
+	if f.Pattern != "" {
+		props["Pattern"] = dbus.MakeVariant(f.Pattern)
+	}
+	return props
+}
+
+// matches checks whether a device with the given properties
+// satisfies the filter.  The Transport and FilterDuplicates fields are ignored.
+func (f DiscoveryFilter) matches(props Properties) bool {
+	if len(f.UUIDs) != 0 {
+		advertised, _ := props["UUIDs"].Value().([]string)
+		found := false
+		for _, u := range f.UUIDs {
+			if ValidUUID(u) && stringsContain(advertised, LongUUID(u)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	rssi, haveRSSI := props["RSSI"].Value().(int16)
+	if f.RSSI != 0 && (!haveRSSI || rssi < f.RSSI) {
+		return false
+	}
+	if f.Pathloss != 0 {
+		power, ok := props["TxPower"].Value().(int16)
+		if !ok || !haveRSSI || int(power)-int(rssi) > int(f.Pathloss) {
+			return false
+		}
+	}
+	if f.Discoverable {
+		// LE Limited or General Discoverable Mode.
+		flags, _ := props["AdvertisingFlags"].Value().([]byte)
+		if len(flags) == 0 || flags[0]&0x03 == 0 {
+			return false
+		}
+	}
+	if f.Pattern != "" {
+		addr, _ := props["Address"].Value().(string)
+		name, _ := props["Name"].Value().(string)
+		if !strings.HasPrefix(addr, f.Pattern) && !strings.HasPrefix(name, f.Pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyDiscoveryFilter sets the adapter's discovery filter.
+// When the package is built with the "nofilter" tag, nothing is sent
+// to BlueZ; Scan and the Discover methods still apply their filter
+// in software.
+func (adapter *blob) ApplyDiscoveryFilter(ctx context.Context, filter DiscoveryFilter) error {
+	return adapter.setDiscoveryFilter(ctx, filter)
+}
+
+func (adapter *blob) SetDiscoveryFilter(uuids ...string) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.SetDiscoveryFilterContext(ctx, uuids...)
+}
+
+func (adapter *blob) SetDiscoveryFilterContext(ctx context.Context, uuids ...string) error {
+	return adapter.ApplyDiscoveryFilter(ctx, DiscoveryFilter{UUIDs: uuids})
+}
+
+func (adapter *blob) GetDiscoveryFilters() ([]string, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return adapter.GetDiscoveryFiltersContext(ctx)
+}
+
+// filterMatch checks whether a device with the given properties
+// satisfies the given discovery filter.  The filter is checked here
+// even when BlueZ applies it, since BlueZ merges the filters of all
+// its clients and also signals changes to devices already known.
+// The Discoverable field is only checked when the filter is applied
+// in software, since older versions of BlueZ do not report AdvertisingFlags.
+// The cache is locked, since the properties may belong to it.
+func (adapter *blob) filterMatch(filter DiscoveryFilter, props Properties) bool {
+	conn := adapter.conn
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if !softwareFilter {
+		filter.Discoverable = false
+	}
+	return filter.matches(props)
+}
@@ -2,8 +2,20 @@
 
 package ble
 
+import (
+	"context"
+)
+
 // Discovery filtering doesn't work on Intel Edison running
-// Debian stretch and kernel 3.10.17-yocto-standard-r2.
-func (adapter *blob) SetDiscoveryFilter(uuids ...string) error {
+// Debian stretch and kernel 3.10.17-yocto-standard-r2,
+// so the filter is applied in software instead.
+const softwareFilter = true
+
+func (adapter *blob) setDiscoveryFilter(ctx context.Context, filter DiscoveryFilter) error {
 	return nil
 }
+
+// GetDiscoveryFiltersContext returns the filter keys supported in software.
+func (adapter *blob) GetDiscoveryFiltersContext(ctx context.Context) ([]string, error) {
+	return []string{"UUIDs", "RSSI", "Pathloss", "Discoverable", "Pattern"}, nil
+}
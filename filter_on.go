@@ -3,18 +3,20 @@
 package ble
 
 import (
+	"context"
 	"log"
-
-	"github.com/godbus/dbus"
 )
 
-func (adapter *blob) SetDiscoveryFilter(uuids ...string) error {
-	log.Printf("%s: setting discovery filter %v", adapter.Name(), UUIDs(uuids))
-	return adapter.call(
-		"SetDiscoveryFilter",
-		Properties{
-			"Transport": dbus.MakeVariant("le"),
-			"UUIDs":     dbus.MakeVariant(uuids),
-		},
-	)
+// softwareFilter is false when BlueZ applies the discovery filter.
+const softwareFilter = false
+
+func (adapter *blob) setDiscoveryFilter(ctx context.Context, filter DiscoveryFilter) error {
+	log.Printf("%s: setting discovery filter %v", adapter.Name(), UUIDs(filter.UUIDs))
+	return adapter.callContext(ctx, "SetDiscoveryFilter", filter.properties())
+}
+
+func (adapter *blob) GetDiscoveryFiltersContext(ctx context.Context) ([]string, error) {
+	var filters []string
+	err := adapter.callvContext(ctx, "GetDiscoveryFilters").Store(&filters)
+	return filters, err
 }
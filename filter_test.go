@@ -0,0 +1,81 @@
+package ble
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestFilterProperties(t *testing.T) {
+	cases := []struct {
+		filter DiscoveryFilter
+		props  Properties
+	}{
+		{
+			DiscoveryFilter{},
+			Properties{
+				"Transport": dbus.MakeVariant("le"),
+				"UUIDs":     dbus.MakeVariant([]string{}),
+			},
+		},
+		{
+			DiscoveryFilter{
+				UUIDs:            []string{"180d"},
+				RSSI:             -70,
+				Pathloss:         30,
+				Transport:        "auto",
+				FilterDuplicates: true,
+				Discoverable:     true,
+				Pattern:          "HRM",
+			},
+			Properties{
+				"Transport":     dbus.MakeVariant("auto"),
+				"UUIDs":         dbus.MakeVariant([]string{"180d"}),
+				"RSSI":          dbus.MakeVariant(int16(-70)),
+				"Pathloss":      dbus.MakeVariant(uint16(30)),
+				"DuplicateData": dbus.MakeVariant(false),
+				"Discoverable":  dbus.MakeVariant(true),
+				"Pattern":       dbus.MakeVariant("HRM"),
+			},
+		},
+	}
+	for _, c := range cases {
+		props := c.filter.properties()
+		if !reflect.DeepEqual(props, c.props) {
+			t.Errorf("%+v.properties() == %v, want %v", c.filter, props, c.props)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	device := Properties{
+		"Address":          dbus.MakeVariant("AA:BB:CC:DD:EE:01"),
+		"Name":             dbus.MakeVariant("HRM"),
+		"UUIDs":            dbus.MakeVariant([]string{"0000180d-0000-1000-8000-00805f9b34fb"}),
+		"RSSI":             dbus.MakeVariant(int16(-60)),
+		"TxPower":          dbus.MakeVariant(int16(4)),
+		"AdvertisingFlags": dbus.MakeVariant([]byte{0x06}),
+	}
+	cases := []struct {
+		filter DiscoveryFilter
+		match  bool
+	}{
+		{DiscoveryFilter{}, true},
+		{DiscoveryFilter{UUIDs: []string{"180f", "180d"}}, true},
+		{DiscoveryFilter{UUIDs: []string{"180f"}}, false},
+		{DiscoveryFilter{RSSI: -70}, true},
+		{DiscoveryFilter{RSSI: -50}, false},
+		{DiscoveryFilter{Pathloss: 64}, true},
+		{DiscoveryFilter{Pathloss: 60}, false},
+		{DiscoveryFilter{Discoverable: true}, true},
+		{DiscoveryFilter{Pattern: "AA:BB"}, true},
+		{DiscoveryFilter{Pattern: "HR"}, true},
+		{DiscoveryFilter{Pattern: "XX"}, false},
+	}
+	for _, c := range cases {
+		if c.filter.matches(device) != c.match {
+			t.Errorf("%+v.matches() == %v, want %v", c.filter, !c.match, c.match)
+		}
+	}
+}
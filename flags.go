@@ -0,0 +1,127 @@
+package ble
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GattFlags is a set of GATT characteristic or descriptor flags.
+// See the Flags property in bluez/doc/gatt-api.txt
+type GattFlags uint32
+
+// GATT flags.
+const (
+	FlagBroadcast GattFlags = 1 << iota
+	FlagRead
+	FlagWriteWithoutResponse
+	FlagWrite
+	FlagNotify
+	FlagIndicate
+	FlagAuthenticatedSignedWrites
+	FlagExtendedProperties
+	FlagReliableWrite
+	FlagWritableAuxiliaries
+	FlagEncryptRead
+	FlagEncryptWrite
+	FlagEncryptAuthenticatedRead
+	FlagEncryptAuthenticatedWrite
+	FlagSecureRead
+	FlagSecureWrite
+	FlagAuthorize
+)
+
+var flagNames = []string{
+	"broadcast",
+	"read",
+	"write-without-response",
+	"write",
+	"notify",
+	"indicate",
+	"authenticated-signed-writes",
+	"extended-properties",
+	"reliable-write",
+	"writable-auxiliaries",
+	"encrypt-read",
+	"encrypt-write",
+	"encrypt-authenticated-read",
+	"encrypt-authenticated-write",
+	"secure-read",
+	"secure-write",
+	"authorize",
+}
+
+// Combinations of flags that permit an operation.
+const (
+	readFlags   = FlagRead | FlagEncryptRead | FlagEncryptAuthenticatedRead | FlagSecureRead
+	writeFlags  = FlagWrite | FlagEncryptWrite | FlagEncryptAuthenticatedWrite | FlagSecureWrite
+	notifyFlags = FlagNotify | FlagIndicate
+)
+
+// writeTypeFlags returns the flags that permit a write of the given type.
+func writeTypeFlags(t WriteType) GattFlags {
+	switch t {
+	case WriteCommand:
+		return FlagWriteWithoutResponse | FlagAuthenticatedSignedWrites
+	case WriteRequest:
+		return writeFlags
+	case WriteReliable:
+		return FlagReliableWrite
+	default:
+		return writeFlags | FlagWriteWithoutResponse | FlagAuthenticatedSignedWrites | FlagReliableWrite
+	}
+}
+
+// ParseGattFlags converts BlueZ flag names to a GattFlags value.
+// Unknown names are ignored.
+func ParseGattFlags(names []string) GattFlags {
+	var flags GattFlags
+	for _, name := range names {
+		for i, s := range flagNames {
+			if name == s {
+				flags |= 1 << uint(i)
+				break
+			}
+		}
+	}
+	return flags
+}
+
+// Has returns whether all the flags in f are set.
+func (flags GattFlags) Has(f GattFlags) bool {
+	return flags&f == f
+}
+
+func (flags GattFlags) String() string {
+	return strings.Join(flags.names(), ",")
+}
+
+// names returns the BlueZ names of the flags.
+func (flags GattFlags) names() []string {
+	names := []string{}
+	for i, s := range flagNames {
+		if flags&(1<<uint(i)) != 0 {
+			names = append(names, s)
+		}
+	}
+	return names
+}
+
+// GattFlags returns the flags of a Characteristic or Descriptor as a GattFlags value.
+func (handle *blob) GattFlags() GattFlags {
+	return ParseGattFlags(handle.Flags())
+}
+
+// checkFlags returns an error wrapping ErrNotSupported
+// if a characteristic or descriptor has none of the flags in want.
+// Nothing is checked if BlueZ does not report the Flags property.
+func (handle *blob) checkFlags(op string, want GattFlags) error {
+	flags, ok := handle.property("Flags").Value().([]string)
+	if !ok || ParseGattFlags(flags)&want != 0 {
+		return nil
+	}
+	kind := "characteristic"
+	if handle.iface == descriptorInterface {
+		kind = "descriptor"
+	}
+	return fmt.Errorf("%w: %s %s does not support %s (flags: %v)", ErrNotSupported, kind, ShortUUID(handle.UUID()), op, flags)
+}
@@ -0,0 +1,45 @@
+package ble
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestGattFlags(t *testing.T) {
+	flags := ParseGattFlags([]string{"read", "write-without-response", "notify", "unknown"})
+	if flags != FlagRead|FlagWriteWithoutResponse|FlagNotify {
+		t.Errorf("ParseGattFlags returned %v", flags)
+	}
+	if !flags.Has(FlagRead|FlagNotify) || flags.Has(FlagRead|FlagWrite) {
+		t.Errorf("Has returned wrong result for %v", flags)
+	}
+	if s := flags.String(); s != "read,write-without-response,notify" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := (FlagAuthorize | FlagBroadcast).String(); s != "broadcast,authorize" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestCheckDescriptorFlags(t *testing.T) {
+	desc := &blob{
+		conn:  &Connection{},
+		iface: descriptorInterface,
+		properties: Properties{
+			"UUID":  dbus.MakeVariant("00002901-0000-1000-8000-00805f9b34fb"),
+			"Flags": dbus.MakeVariant([]string{"read"}),
+		},
+	}
+	if err := desc.checkFlags("read", readFlags); err != nil {
+		t.Errorf("checkFlags(read) returned %v", err)
+	}
+	if err := desc.checkFlags("write", writeFlags); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("checkFlags(write) returned %v, want %v", err, ErrNotSupported)
+	}
+	delete(desc.properties, "Flags")
+	if err := desc.checkFlags("write", writeFlags); err != nil {
+		t.Errorf("checkFlags without Flags returned %v", err)
+	}
+}
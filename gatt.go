@@ -1,21 +1,31 @@
 package ble
 
 import (
+	"context"
 	"fmt"
+	"io"
+
+	"github.com/godbus/dbus"
 )
 
 const (
-	// GATTMTU is the maximum size of a write to a GATT characteristic.
-	GATTMTU = 20
+	// DefaultMTU is the ATT MTU of a link before a larger one is negotiated.
+	DefaultMTU = 23
+
+	// GATTMTU is the maximum size of a write to a GATT characteristic
+	// when the MTU has not been negotiated.
+	// Use Characteristic.MTU to find the negotiated value.
+	GATTMTU = DefaultMTU - 3
 
 	serviceInterface        = "org.bluez.GattService1"
 	characteristicInterface = "org.bluez.GattCharacteristic1"
 	descriptorInterface     = "org.bluez.GattDescriptor1"
 )
 
-func (conn *Connection) findGattObject(iface string, uuid string) (*blob, error) {
+// findGattObject finds a GATT object below the given root (if nonempty) with the given UUID.
+func (conn *Connection) findGattObject(root dbus.ObjectPath, iface string, uuid string) (*blob, error) {
 	handle, err := conn.findObject(iface, func(desc *blob) bool {
-		return desc.UUID() == uuid
+		return inTree(desc.path, root) && desc.UUID() == uuid
 	})
 	if err != nil {
 		err = fmt.Errorf("%w with UUID %s", err, uuid)
@@ -23,48 +33,244 @@ func (conn *Connection) findGattObject(iface string, uuid string) (*blob, error)
 	return handle, err
 }
 
+// findGattObjects finds all the GATT objects below the given root, in path order.
+func (conn *Connection) findGattObjects(root dbus.ObjectPath, iface string) []*blob {
+	return conn.findObjects(iface, func(handle *blob) bool {
+		return inTree(handle.path, root)
+	})
+}
+
 // GattHandle is the interface satisfied by GATT handles.
 type GattHandle interface {
 	BaseObject
 
 	UUID() string
+	Handle() uint16
 }
 
 // UUID returns the handle's UUID
 func (handle *blob) UUID() string {
-	return handle.properties["UUID"].Value().(string)
+	return handle.property("UUID").Value().(string)
+}
+
+// Handle returns the handle's attribute handle,
+// or 0 if BlueZ does not provide it.
+func (handle *blob) Handle() uint16 {
+	h, _ := handle.property("Handle").Value().(uint16)
+	return h
 }
 
 // Service corresponds to the org.bluez.GattService1 interface.
 // See bluez/doc/gatt-api.txt
+//
+// GetCharacteristic finds a characteristic of the service with the given UUID.
+//
+// Characteristics returns the characteristics of the service, in handle order.
+//
+// Primary returns whether the service is a primary service.
+//
+// Includes returns the services included by the service.
 type Service interface {
 	GattHandle
+
+	GetCharacteristic(uuid string) (Characteristic, error)
+	Characteristics() []Characteristic
+
+	Primary() bool
+	Includes() []Service
+}
+
+// Services returns the services of the device, in handle order.
+func (device *blob) Services() []Service {
+	found := device.conn.findGattObjects(device.path, serviceInterface)
+	services := make([]Service, len(found))
+	for i, service := range found {
+		services[i] = service
+	}
+	return services
+}
+
+// Characteristics returns the characteristics of the service, in handle order.
+func (service *blob) Characteristics() []Characteristic {
+	found := service.conn.findGattObjects(service.path, characteristicInterface)
+	chars := make([]Characteristic, len(found))
+	for i, char := range found {
+		chars[i] = char
+	}
+	return chars
+}
+
+// Primary returns whether the service is a primary service.
+func (service *blob) Primary() bool {
+	primary, _ := service.property("Primary").Value().(bool)
+	return primary
+}
+
+// Includes returns the services included by the service.
+func (service *blob) Includes() []Service {
+	paths, _ := service.property("Includes").Value().([]dbus.ObjectPath)
+	var services []Service
+	for _, p := range paths {
+		found := service.conn.findObjects(serviceInterface, func(s *blob) bool {
+			return s.path == p
+		})
+		if len(found) != 0 {
+			services = append(services, found[0])
+		}
+	}
+	return services
 }
 
 // GetService finds a Service with the given UUID.
 func (conn *Connection) GetService(uuid string) (Service, error) {
-	return conn.findGattObject(serviceInterface, uuid)
+	return conn.findGattObject("", serviceInterface, uuid)
+}
+
+// GetService finds a Service of the device with the given UUID.
+func (device *blob) GetService(uuid string) (Service, error) {
+	return device.conn.findGattObject(device.path, serviceInterface, uuid)
 }
 
 // ReadWriteHandle is the interface satisfied by GATT objects
 // that provide ReadValue and WriteValue operations.
+//
+// ReadValueAt reads the part of the value starting at the given offset,
+// so long values can be read in pieces.
+//
+// WriteValueWithOptions writes a value using the given WriteOptions.
 type ReadWriteHandle interface {
 	GattHandle
 
 	ReadValue() ([]byte, error)
+	ReadValueAt(offset uint16) ([]byte, error)
 	WriteValue([]byte) error
+	WriteValueWithOptions([]byte, WriteOptions) error
+
+	ReadValueContext(context.Context) ([]byte, error)
+	ReadValueAtContext(ctx context.Context, offset uint16) ([]byte, error)
+	WriteValueContext(context.Context, []byte) error
+	WriteValueWithOptionsContext(context.Context, []byte, WriteOptions) error
+}
+
+// WriteType specifies the GATT procedure used to write a characteristic value.
+type WriteType int
+
+// Write types.  With WriteDefault, BlueZ chooses the write type
+// based on the characteristic's flags.
+const (
+	WriteDefault  WriteType = iota
+	WriteCommand            // write without response
+	WriteRequest            // write with response
+	WriteReliable           // reliable (prepared) write
+)
+
+func (t WriteType) String() string {
+	switch t {
+	case WriteDefault:
+		return ""
+	case WriteCommand:
+		return "command"
+	case WriteRequest:
+		return "request"
+	case WriteReliable:
+		return "reliable"
+	default:
+		return fmt.Sprintf("WriteType(%d)", int(t))
+	}
+}
+
+// WriteOptions control how a value is written.
+// Type is only used for characteristics; it is ignored for descriptors.
+type WriteOptions struct {
+	Type   WriteType
+	Offset uint16
+
+	// PrepareAuthorize marks the write as a prepared write
+	// that requires authorization.
+	PrepareAuthorize bool
+}
+
+// properties returns the options dictionary for the WriteValue method.
+func (opts WriteOptions) properties() Properties {
+	props := Properties{}
+	if opts.Type != WriteDefault {
+		props["type"] = dbus.MakeVariant(opts.Type.String())
+	}
+	if opts.Offset != 0 {
+		props["offset"] = dbus.MakeVariant(opts.Offset)
+	}
+	if opts.PrepareAuthorize {
+		props["prepare-authorize"] = dbus.MakeVariant(true)
+	}
+	return props
 }
 
 // ReadValue reads the handle's value.
 func (handle *blob) ReadValue() ([]byte, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.ReadValueContext(ctx)
+}
+
+// ReadValueContext reads the handle's value, using the given context.
+func (handle *blob) ReadValueContext(ctx context.Context) ([]byte, error) {
+	return handle.ReadValueAtContext(ctx, 0)
+}
+
+// ReadValueAt reads the handle's value, starting at the given offset.
+func (handle *blob) ReadValueAt(offset uint16) ([]byte, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.ReadValueAtContext(ctx, offset)
+}
+
+// ReadValueAtContext reads the handle's value, starting at the given offset,
+// using the given context.
+func (handle *blob) ReadValueAtContext(ctx context.Context, offset uint16) ([]byte, error) {
+	err := handle.checkFlags("read", readFlags)
+	if err != nil {
+		return nil, err
+	}
+	opts := Properties{}
+	if offset != 0 {
+		opts["offset"] = dbus.MakeVariant(offset)
+	}
 	var data []byte
-	err := handle.callv("ReadValue", Properties{}).Store(&data)
+	err = handle.callvContext(ctx, "ReadValue", opts).Store(&data)
 	return data, err
 }
 
 // WriteValue writes a value to the handle.
 func (handle *blob) WriteValue(data []byte) error {
-	return handle.call("WriteValue", data, Properties{})
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.WriteValueContext(ctx, data)
+}
+
+// WriteValueContext writes a value to the handle, using the given context.
+func (handle *blob) WriteValueContext(ctx context.Context, data []byte) error {
+	return handle.WriteValueWithOptionsContext(ctx, data, WriteOptions{})
+}
+
+// WriteValueWithOptions writes a value to the handle, using the given options.
+func (handle *blob) WriteValueWithOptions(data []byte, opts WriteOptions) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.WriteValueWithOptionsContext(ctx, data, opts)
+}
+
+// WriteValueWithOptionsContext writes a value to the handle,
+// using the given context and options.
+func (handle *blob) WriteValueWithOptionsContext(ctx context.Context, data []byte, opts WriteOptions) error {
+	op := "write"
+	if opts.Type != WriteDefault {
+		op = opts.Type.String() + " write"
+	}
+	err := handle.checkFlags(op, writeTypeFlags(opts.Type))
+	if err != nil {
+		return err
+	}
+	return handle.callContext(ctx, "WriteValue", data, opts.properties())
 }
 
 // NotifyHandler represents a function that handles notifications.
@@ -72,6 +278,33 @@ type NotifyHandler func([]byte)
 
 // Characteristic corresponds to the org.bluez.GattCharacteristic1 interface.
 // See bluez/doc/gatt-api.txt
+//
+// HandleNotify enables notifications and applies the given handler to them.
+// By default, each characteristic's notifications are delivered to its handler
+// sequentially, in the order they arrive, through a queue of
+// DefaultNotifyBufferSize entries; use HandleNotifyWithOptions to change this.
+// NotifyDropped returns the number of notifications dropped because
+// the queue was full.
+//
+// RemoveNotify undoes HandleNotify: it stops notifications,
+// and removes the handler and the D-Bus match rule for them.
+//
+// Notifications provides a channel-based alternative to HandleNotify.
+//
+// AcquireNotify and AcquireWrite provide socket-based alternatives
+// to notifications and to writes without response.
+//
+// GetDescriptor finds a descriptor of the characteristic with the given UUID.
+//
+// Descriptors returns the descriptors of the characteristic, in handle order.
+//
+// Flags returns the characteristic's flags, such as "read" or "notify",
+// and GattFlags returns them as a GattFlags value.
+// Operations that the flags do not permit fail with an error
+// wrapping ErrNotSupported, without calling BlueZ.
+//
+// MTU returns the negotiated ATT MTU of the link.
+// WriteLong and Writer split values into writes that fit within it.
 type Characteristic interface {
 	ReadWriteHandle
 
@@ -81,35 +314,115 @@ type Characteristic interface {
 	StopNotify() error
 
 	HandleNotify(NotifyHandler) error
+
+	StartNotifyContext(context.Context) error
+	StopNotifyContext(context.Context) error
+
+	HandleNotifyContext(context.Context, NotifyHandler) error
+	HandleNotifyWithOptions(context.Context, NotifyHandler, NotifyOptions) error
+	NotifyDropped() uint64
+
+	RemoveNotify() error
+	RemoveNotifyContext(context.Context) error
+
+	Notifications(context.Context) (<-chan Notification, error)
+
+	AcquireNotify() (io.ReadCloser, uint16, error)
+	AcquireWrite() (io.WriteCloser, uint16, error)
+
+	AcquireNotifyContext(context.Context) (io.ReadCloser, uint16, error)
+	AcquireWriteContext(context.Context) (io.WriteCloser, uint16, error)
+
+	GetDescriptor(uuid string) (Descriptor, error)
+	Descriptors() []Descriptor
+
+	Flags() []string
+	GattFlags() GattFlags
+
+	MTU() uint16
+	WriteLong([]byte, LongWriteOptions) error
+	WriteLongContext(context.Context, []byte, LongWriteOptions) error
+	Writer(LongWriteOptions) io.Writer
 }
 
 // GetCharacteristic finds a Characteristic with the given UUID.
 func (conn *Connection) GetCharacteristic(uuid string) (Characteristic, error) {
-	return conn.findGattObject(characteristicInterface, uuid)
+	return conn.findGattObject("", characteristicInterface, uuid)
+}
+
+// GetCharacteristic finds a Characteristic of the service with the given UUID.
+func (service *blob) GetCharacteristic(uuid string) (Characteristic, error) {
+	return service.conn.findGattObject(service.path, characteristicInterface, uuid)
+}
+
+// Descriptors returns the descriptors of the characteristic, in handle order.
+func (char *blob) Descriptors() []Descriptor {
+	found := char.conn.findGattObjects(char.path, descriptorInterface)
+	descs := make([]Descriptor, len(found))
+	for i, desc := range found {
+		descs[i] = desc
+	}
+	return descs
+}
+
+// Flags returns the flags of a Characteristic or Descriptor,
+// as defined in bluez/doc/gatt-api.txt.
+func (handle *blob) Flags() []string {
+	flags, _ := handle.property("Flags").Value().([]string)
+	return flags
 }
 
 // Notifying returns whether or not a Characteristic is notifying.
 func (handle *blob) Notifying() bool {
-	return handle.properties["Notifying"].Value().(bool)
+	return handle.property("Notifying").Value().(bool)
 }
 
 // StartNotify starts notifying.
 func (handle *blob) StartNotify() error {
-	return handle.call("StartNotify")
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.StartNotifyContext(ctx)
+}
+
+// StartNotifyContext starts notifying, using the given context.
+func (handle *blob) StartNotifyContext(ctx context.Context) error {
+	err := handle.checkFlags("notify", notifyFlags)
+	if err != nil {
+		return err
+	}
+	return handle.callContext(ctx, "StartNotify")
 }
 
-// StartNotify stops notifying.
+// StopNotify stops notifying.
 func (handle *blob) StopNotify() error {
-	return handle.call("StopNotify")
+	ctx, cancel := callContext()
+	defer cancel()
+	return handle.StopNotifyContext(ctx)
+}
+
+// StopNotifyContext stops notifying, using the given context.
+func (handle *blob) StopNotifyContext(ctx context.Context) error {
+	return handle.callContext(ctx, "StopNotify")
 }
 
 // Descriptor corresponds to the org.bluez.GattDescriptor1 interface.
 // See bluez/doc/gatt-api.txt
+//
+// Flags returns the descriptor's flags, such as "read" or "write",
+// and GattFlags returns them as a GattFlags value.
 type Descriptor interface {
 	ReadWriteHandle
+
+	Flags() []string
+	GattFlags() GattFlags
 }
 
 // GetDescriptor finds a Descriptor with the given UUID.
 func (conn *Connection) GetDescriptor(uuid string) (Descriptor, error) {
-	return conn.findGattObject(descriptorInterface, uuid)
+	return conn.findGattObject("", descriptorInterface, uuid)
+}
+
+// GetDescriptor finds a Descriptor of the characteristic with the given UUID.
+func (char *blob) GetDescriptor(uuid string) (Descriptor, error) {
+	return char.conn.findGattObject(char.path, descriptorInterface, uuid)
 }
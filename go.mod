@@ -1,5 +1,5 @@
 module github.com/ecc1/ble
 
-go 1.13
+go 1.14
 
 require github.com/godbus/dbus v4.1.0+incompatible
@@ -0,0 +1,144 @@
+package ble
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A Matcher decides whether a discovered device is the one wanted.
+// Matchers are composed with MatchAll and MatchAny.
+// A nil Matcher matches every device.
+type Matcher func(Device) bool
+
+// MatchAll returns a Matcher that matches devices matched by all of ms.
+func MatchAll(ms ...Matcher) Matcher {
+	return func(device Device) bool {
+		for _, m := range ms {
+			if m != nil && !m(device) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny returns a Matcher that matches devices matched by any of ms.
+func MatchAny(ms ...Matcher) Matcher {
+	return func(device Device) bool {
+		for _, m := range ms {
+			if m == nil || m(device) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAddress matches the device with the given address.
+func MatchAddress(address Address) Matcher {
+	addr := Address(strings.ToUpper(string(address)))
+	return func(device Device) bool {
+		return device.Address() == addr
+	}
+}
+
+// MatchAddressType matches devices with the given address type
+// ("public" or "random").
+func MatchAddressType(addressType string) Matcher {
+	return func(device Device) bool {
+		return device.AddressType() == addressType
+	}
+}
+
+// deviceName returns the device's advertised name, or "" if it has none.
+func deviceName(device Device) string {
+	name := device.Name()
+	if name == string(device.Path()) {
+		return ""
+	}
+	return name
+}
+
+// MatchName matches devices with the given name.
+func MatchName(name string) Matcher {
+	return func(device Device) bool {
+		return deviceName(device) == name
+	}
+}
+
+// MatchNamePrefix matches devices whose name begins with the given prefix.
+func MatchNamePrefix(prefix string) Matcher {
+	return func(device Device) bool {
+		name := deviceName(device)
+		return name != "" && strings.HasPrefix(name, prefix)
+	}
+}
+
+// MatchNameRegexp matches devices whose name matches the given regular expression.
+func MatchNameRegexp(re *regexp.Regexp) Matcher {
+	return func(device Device) bool {
+		name := deviceName(device)
+		return name != "" && re.MatchString(name)
+	}
+}
+
+// MatchUUIDs matches devices advertising all of the given UUIDs.
+func MatchUUIDs(uuids ...string) Matcher {
+	return func(device Device) bool {
+		return UUIDsInclude(device.UUIDs(), uuids)
+	}
+}
+
+// MatchMinRSSI matches devices whose RSSI is at least the given value.
+func MatchMinRSSI(rssi int16) Matcher {
+	return func(device Device) bool {
+		r, ok := device.RSSI()
+		return ok && r >= rssi
+	}
+}
+
+// MatchManufacturerData matches devices advertising manufacturer data
+// for the given company identifier that begins with data,
+// comparing only the bits set in mask.  A nil mask compares all bits.
+func MatchManufacturerData(company uint16, data []byte, mask []byte) Matcher {
+	return func(device Device) bool {
+		adv, ok := device.ManufacturerData()[company]
+		return ok && maskedPrefix(adv, data, mask)
+	}
+}
+
+// MatchServiceData matches devices advertising service data
+// for the given UUID that begins with data,
+// comparing only the bits set in mask.  A nil mask compares all bits.
+// An invalid UUID matches no devices.
+func MatchServiceData(uuid string, data []byte, mask []byte) Matcher {
+	u := strings.ToLower(uuid)
+	if ValidUUID(u) {
+		u = LongUUID(u)
+	}
+	return func(device Device) bool {
+		adv, ok := device.ServiceData()[u]
+		return ok && maskedPrefix(adv, data, mask)
+	}
+}
+
+// maskedPrefix checks whether adv begins with data,
+// comparing only the bits set in mask.
+func maskedPrefix(adv []byte, data []byte, mask []byte) bool {
+	if len(adv) < len(data) {
+		return false
+	}
+	for i, b := range data {
+		m := byte(0xFF)
+		if mask != nil {
+			if i >= len(mask) {
+				break
+			}
+			m = mask[i]
+		}
+		if adv[i]&m != b&m {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package ble
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestMaskedPrefix(t *testing.T) {
+	cases := []struct {
+		adv, data, mask []byte
+		match           bool
+	}{
+		{[]byte{1, 2, 3}, []byte{1, 2}, nil, true},
+		{[]byte{1, 2, 3}, []byte{1, 3}, nil, false},
+		{[]byte{1}, []byte{1, 2}, nil, false},
+		{[]byte{0x12, 0x34}, []byte{0x10, 0x34}, []byte{0xF0, 0xFF}, true},
+		{[]byte{0x22, 0x34}, []byte{0x10, 0x34}, []byte{0xF0, 0xFF}, false},
+		{[]byte{0x12, 0x99}, []byte{0x12, 0x34}, []byte{0xFF}, true},
+		{[]byte{}, []byte{}, nil, true},
+	}
+	for _, c := range cases {
+		if maskedPrefix(c.adv, c.data, c.mask) != c.match {
+			t.Errorf("maskedPrefix(%x, %x, %x) == %v, want %v", c.adv, c.data, c.mask, !c.match, c.match)
+		}
+	}
+}
+
+func TestMatchServiceData(t *testing.T) {
+	device := &blob{
+		conn: &Connection{},
+		properties: Properties{
+			"ServiceData": dbus.MakeVariant(map[string]dbus.Variant{
+				"0000180d-0000-1000-8000-00805f9b34fb": dbus.MakeVariant([]byte{1, 2}),
+			}),
+		},
+	}
+	cases := []struct {
+		uuid  string
+		match bool
+	}{
+		{"180d", true},
+		{"180D", true},
+		{"0000180D-0000-1000-8000-00805F9B34FB", true},
+		{"180f", false},
+		{"not a UUID", false},
+	}
+	for _, c := range cases {
+		if MatchServiceData(c.uuid, []byte{1}, nil)(device) != c.match {
+			t.Errorf("MatchServiceData(%q) == %v, want %v", c.uuid, !c.match, c.match)
+		}
+	}
+}
@@ -1,44 +1,377 @@
 package ble
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/godbus/dbus"
 )
 
-var (
-	notifySignals = make(chan *dbus.Signal, 100)
-	notifyHandler = make(map[dbus.ObjectPath]NotifyHandler)
+const (
+	// DefaultNotifyBufferSize is the number of notifications
+	// that can be queued for a handler before further ones are dropped.
+	DefaultNotifyBufferSize = 100
 )
 
+// NotifyOptions control how notifications are delivered to a NotifyHandler.
+type NotifyOptions struct {
+	// BufferSize is the number of notifications that can be queued
+	// for the handler; when the queue is full, further notifications
+	// are dropped and counted.  If zero, DefaultNotifyBufferSize is used.
+	BufferSize int
+
+	// Unordered causes each notification to be delivered
+	// in its own goroutine, so the handler may be called concurrently
+	// and notifications may be handled in any order.
+	// By default, the handler is called sequentially, in arrival order.
+	Unordered bool
+}
+
+// A Notification is a value received from a characteristic,
+// together with the time at which it was received.
+type Notification struct {
+	Value []byte
+	Time  time.Time
+}
+
+// A notifyQueue delivers the notifications for one characteristic
+// to its handler.
+type notifyQueue struct {
+	handler   func(Notification)
+	unordered bool
+	onStop    func()
+	dropped   uint64 // accessed atomically
+
+	mu     sync.Mutex
+	values chan Notification
+	closed bool
+}
+
+func newNotifyQueue(handler func(Notification), opts NotifyOptions, onStop func()) *notifyQueue {
+	q := &notifyQueue{
+		handler:   handler,
+		unordered: opts.Unordered,
+		onStop:    onStop,
+	}
+	if !q.unordered {
+		size := opts.BufferSize
+		if size <= 0 {
+			size = DefaultNotifyBufferSize
+		}
+		q.values = make(chan Notification, size)
+		go q.run()
+	}
+	return q
+}
+
+func (q *notifyQueue) run() {
+	for n := range q.values {
+		q.handler(n)
+	}
+	if q.onStop != nil {
+		q.onStop()
+	}
+}
+
+// deliver queues a notification for the handler, or drops it if the queue is full.
+func (q *notifyQueue) deliver(n Notification) {
+	if q.unordered {
+		go q.handler(n)
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.values <- n:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+// stop stops the queue after the notifications already in it are handled.
+func (q *notifyQueue) stop() {
+	if q.unordered {
+		if q.onStop != nil {
+			q.onStop()
+		}
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.values)
+	}
+}
+
+// discard stops the queue and drops the notifications not yet handled,
+// so its goroutine exits once the handler returns.
+func (q *notifyQueue) discard() {
+	q.stop()
+	if q.unordered {
+		return
+	}
+	for range q.values {
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+// stopNotifyQueues discards all the connection's notification queues.
+func (conn *Connection) stopNotifyQueues() {
+	conn.notifyMu.Lock()
+	defer conn.notifyMu.Unlock()
+	for path, q := range conn.notifyQueues {
+		q.discard()
+		delete(conn.notifyQueues, path)
+	}
+}
+
 func (char *blob) HandleNotify(handler NotifyHandler) error {
-	conn := char.conn
-	if len(notifyHandler) == 0 {
-		go notifyLoop()
-		conn.bus.Signal(notifySignals)
+	ctx, cancel := callContext()
+	defer cancel()
+	return char.HandleNotifyContext(ctx, handler)
+}
+
+func (char *blob) HandleNotifyContext(ctx context.Context, handler NotifyHandler) error {
+	return char.HandleNotifyWithOptions(ctx, handler, NotifyOptions{})
+}
+
+func (char *blob) HandleNotifyWithOptions(ctx context.Context, handler NotifyHandler, opts NotifyOptions) error {
+	return char.handleNotify(ctx, newNotifyQueue(func(n Notification) { handler(n.Value) }, opts, nil))
+}
+
+// handleNotify installs q as the notification queue for the characteristic,
+// replacing any previous one, and enables notifications if necessary.
+func (char *blob) handleNotify(ctx context.Context, q *notifyQueue) error {
+	err := char.checkFlags("notify", notifyFlags)
+	if err != nil {
+		q.stop()
+		return err
 	}
+	conn := char.conn
 	path := char.Path()
-	prev := notifyHandler[path]
-	notifyHandler[path] = handler
+	conn.notifyMu.Lock()
+	prev := conn.notifyQueues[path]
+	conn.notifyQueues[path] = q
+	conn.notifyMu.Unlock()
 	if prev != nil {
+		prev.stop()
 		return nil
 	}
-	rule := fmt.Sprintf(
+	err = conn.addMatch(ctx, notifyRule(path))
+	if err != nil {
+		char.abandonNotify(q, false)
+		return err
+	}
+	err = char.StartNotifyContext(ctx)
+	if err != nil {
+		char.abandonNotify(q, true)
+		return err
+	}
+	return nil
+}
+
+// abandonNotify undoes a failed handleNotify, unless q has already
+// been replaced, so that a later attempt starts from scratch.
+func (char *blob) abandonNotify(q *notifyQueue, matched bool) {
+	conn := char.conn
+	path := char.Path()
+	conn.notifyMu.Lock()
+	cur := conn.notifyQueues[path] == q
+	if cur {
+		delete(conn.notifyQueues, path)
+	}
+	conn.notifyMu.Unlock()
+	if !cur {
+		return
+	}
+	q.stop()
+	if matched {
+		conn.removeMatchRule(notifyRule(path))
+	}
+}
+
+func notifyRule(path dbus.ObjectPath) string {
+	return fmt.Sprintf(
 		"type='signal',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='%s'",
 		path,
 	)
-	err := conn.addMatch(rule)
+}
+
+func (char *blob) RemoveNotify() error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return char.RemoveNotifyContext(ctx)
+}
+
+// RemoveNotifyContext stops notifications on the device,
+// then removes the handler and its match rule.
+// The handler's goroutine exits after handling any notifications
+// that are already queued.
+func (char *blob) RemoveNotifyContext(ctx context.Context) error {
+	return char.removeNotify(ctx, nil)
+}
+
+// removeNotify removes the characteristic's notification queue,
+// provided it is the given one (or any, if q is nil).
+func (char *blob) removeNotify(ctx context.Context, q *notifyQueue) error {
+	conn := char.conn
+	path := char.Path()
+	conn.notifyMu.Lock()
+	cur := conn.notifyQueues[path]
+	if q != nil && cur != q {
+		cur = nil
+	}
+	if cur != nil {
+		delete(conn.notifyQueues, path)
+	}
+	conn.notifyMu.Unlock()
+	if cur == nil {
+		return nil
+	}
+	cur.stop()
+	err := char.StopNotifyContext(ctx)
+	matchErr := conn.removeMatch(ctx, notifyRule(path))
 	if err != nil {
 		return err
 	}
-	return char.StartNotify()
+	return matchErr
+}
+
+// NotifyDropped returns the number of notifications that have been dropped
+// because the current handler's queue was full.
+func (char *blob) NotifyDropped() uint64 {
+	conn := char.conn
+	conn.notifyMu.Lock()
+	q := conn.notifyQueues[char.Path()]
+	conn.notifyMu.Unlock()
+	if q == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&q.dropped)
 }
 
-func applyHandler(s *dbus.Signal) {
-	handler := notifyHandler[s.Path]
-	if handler == nil {
-		log.Printf("%s: no notify handler", s.Path)
+// Notifications enables notifications from the characteristic
+// and returns a channel on which they are delivered, in arrival order.
+// The channel is closed, and notifications are disabled, when the context
+// is done or the device disconnects.
+// Like HandleNotify, it replaces any handler previously installed
+// for the characteristic.
+func (char *blob) Notifications(ctx context.Context) (<-chan Notification, error) {
+	conn := char.conn
+	devPath := devicePath(char.Path())
+	rules := []string{
+		"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.ObjectManager',member='InterfacesRemoved'",
+		fmt.Sprintf(
+			"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='%s'",
+			devPath,
+		),
+	}
+	for i, rule := range rules {
+		err := conn.addMatch(ctx, rule)
+		if err != nil {
+			for _, r := range rules[:i] {
+				conn.removeMatchRule(r)
+			}
+			return nil, err
+		}
+	}
+	signals, unsubscribe := conn.subscribe()
+	out := make(chan Notification)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	q := newNotifyQueue(
+		func(n Notification) {
+			select {
+			case out <- n:
+			case <-done:
+			}
+		},
+		NotifyOptions{},
+		func() { close(stopped) },
+	)
+	teardown := func() {
+		unsubscribe()
+		close(done)
+		ctx, cancel := callContext()
+		defer cancel()
+		err := char.removeNotify(ctx, q)
+		if err != nil {
+			log.Printf("%s: %v", char.Path(), err)
+		}
+		for _, rule := range rules {
+			conn.removeMatchRule(rule)
+		}
+	}
+	err := char.handleNotify(ctx, q)
+	if err != nil {
+		teardown()
+		return nil, err
+	}
+	go func() {
+		// Close the channel only after teardown has finished,
+		// so the caller knows that notifications are disabled.
+		defer func() {
+			teardown()
+			<-stopped
+			close(out)
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stopped:
+				// Replaced by another handler.
+				return
+			case s, ok := <-signals:
+				if !ok || char.disconnected(s, devPath) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// disconnected checks whether the signal indicates that the device
+// at devPath has disconnected or that the characteristic has been removed.
+func (char *blob) disconnected(s *dbus.Signal, devPath dbus.ObjectPath) bool {
+	switch s.Name {
+	case propertiesChanged:
+		if s.Path != devPath {
+			return false
+		}
+		var iface string
+		var changed Properties
+		var invalidated []string
+		if dbus.Store(s.Body, &iface, &changed, &invalidated) != nil || iface != deviceInterface {
+			return false
+		}
+		connected, ok := changed["Connected"].Value().(bool)
+		return ok && !connected
+	case interfacesRemoved:
+		var path dbus.ObjectPath
+		var ifaces []string
+		if dbus.Store(s.Body, &path, &ifaces) != nil {
+			return false
+		}
+		return path == char.Path() || path == devPath
+	}
+	return false
+}
+
+func (conn *Connection) applyHandler(s *dbus.Signal) {
+	conn.notifyMu.Lock()
+	q := conn.notifyQueues[s.Path]
+	conn.notifyMu.Unlock()
+	if q == nil || len(s.Body) < 2 {
 		return
 	}
 	// Reflection used by dbus.Store() requires explicit type here.
@@ -46,22 +379,42 @@ func applyHandler(s *dbus.Signal) {
 	_ = dbus.Store(s.Body[1:2], &changed)
 	data, ok := changed["Value"].Value().([]byte)
 	if ok {
-		go handler(data)
-	}
-}
-
-func notifyLoop() {
-	for s := range notifySignals {
-		applyHandler(s)
+		q.deliver(Notification{Value: data, Time: time.Now()})
 	}
 }
 
 // HandleNotify enables notifications from the GATT characterisitc with
 // the specified UUID and applies the given handler to them when they arrive.
 func (conn *Connection) HandleNotify(uuid string, handler NotifyHandler) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return conn.HandleNotifyContext(ctx, uuid, handler)
+}
+
+// HandleNotifyContext is like HandleNotify but uses the given context
+// instead of the default call timeout.
+func (conn *Connection) HandleNotifyContext(ctx context.Context, uuid string, handler NotifyHandler) error {
+	char, err := conn.GetCharacteristic(uuid)
+	if err != nil {
+		return err
+	}
+	return char.HandleNotifyContext(ctx, handler)
+}
+
+// RemoveNotify disables notifications from the GATT characteristic with
+// the specified UUID and removes its handler.
+func (conn *Connection) RemoveNotify(uuid string) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	return conn.RemoveNotifyContext(ctx, uuid)
+}
+
+// RemoveNotifyContext is like RemoveNotify but uses the given context
+// instead of the default call timeout.
+func (conn *Connection) RemoveNotifyContext(ctx context.Context, uuid string) error {
 	char, err := conn.GetCharacteristic(uuid)
 	if err != nil {
 		return err
 	}
-	return char.HandleNotify(handler)
+	return char.RemoveNotifyContext(ctx)
 }
@@ -0,0 +1,248 @@
+package ble
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/godbus/dbus"
+)
+
+// ScanEventType indicates what a ScanEvent reports.
+type ScanEventType int
+
+const (
+	// DeviceFound reports the first advertisement received from a device
+	// during a scan.
+	DeviceFound ScanEventType = iota
+	// DeviceUpdated reports a subsequent change in a device's properties,
+	// such as RSSI, ManufacturerData, or ServiceData.
+	DeviceUpdated
+	// DeviceLost reports that BlueZ has removed a device
+	// that was found during the scan.
+	DeviceLost
+)
+
+func (t ScanEventType) String() string {
+	switch t {
+	case DeviceFound:
+		return "found"
+	case DeviceUpdated:
+		return "updated"
+	case DeviceLost:
+		return "lost"
+	default:
+		return fmt.Sprintf("ScanEventType(%d)", int(t))
+	}
+}
+
+// ScanEvent is delivered by Scan for each advertisement or update.
+// Changed contains the properties that were advertised or changed;
+// it is nil for DeviceLost events.
+type ScanEvent struct {
+	Type    ScanEventType
+	Device  Device
+	Changed Properties
+}
+
+// scanRules returns the match rules for the signals needed by Scan.
+func (adapter *blob) scanRules() []string {
+	return []string{
+		"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.ObjectManager'",
+		fmt.Sprintf(
+			"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='%s',path_namespace='%s'",
+			deviceInterface,
+			adapter.path,
+		),
+	}
+}
+
+// Scan puts the adapter in discovery mode and returns a channel
+// on which an event is delivered for every device matching
+// the given filter that is found, and for every subsequent update
+// of its properties, in the order they arrive.
+// Scanning continues until the context is done;
+// discovery mode is then stopped and the channel is closed.
+// The object cache is kept up to date for the devices reported.
+// The caller must keep receiving from the channel; if it falls behind,
+// signals are dropped (see SignalsDropped) and events may be missed.
+//
+// Since BlueZ keeps a single discovery filter per client, only one scan
+// (including DiscoverMatching and DiscoverAll) can be in progress on an
+// adapter at a time; a second one returns an error satisfying
+// errors.Is(err, ErrInProgress).
+func (adapter *blob) Scan(ctx context.Context, filter DiscoveryFilter) (<-chan ScanEvent, error) {
+	conn := adapter.conn
+	err := adapter.startScan()
+	if err != nil {
+		return nil, err
+	}
+	signals, unsubscribe := conn.subscribe()
+	rules := adapter.scanRules()
+	teardown := func(n int) {
+		unsubscribe()
+		for _, rule := range rules[:n] {
+			conn.removeMatchRule(rule)
+		}
+		adapter.endScan()
+	}
+	// Each call uses the default timeout as well as ctx,
+	// which may have no deadline.
+	call := func(f func(context.Context) error) error {
+		callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		return f(callCtx)
+	}
+	for i, rule := range rules {
+		err := call(func(ctx context.Context) error { return conn.addMatch(ctx, rule) })
+		if err != nil {
+			teardown(i)
+			return nil, err
+		}
+	}
+	err = call(func(ctx context.Context) error { return adapter.ApplyDiscoveryFilter(ctx, filter) })
+	if err == nil {
+		// Load the devices that BlueZ already knows about,
+		// since only their changes will be signaled.
+		err = call(conn.UpdateContext)
+	}
+	if err == nil {
+		err = call(adapter.StartDiscoveryContext)
+	}
+	if err != nil {
+		teardown(len(rules))
+		return nil, err
+	}
+	events := make(chan ScanEvent)
+	go func() {
+		defer close(events)
+		defer teardown(len(rules))
+		// Use a fresh context, since ctx is done by now.
+		defer adapter.StopDiscovery()
+		adapter.scanLoop(ctx, filter, signals, events)
+	}()
+	return events, nil
+}
+
+// startScan records that a scan is in progress on the adapter,
+// or returns ErrInProgress if one already is.
+func (adapter *blob) startScan() error {
+	conn := adapter.conn
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.scanning[adapter.path] {
+		return fmt.Errorf("%w: %s is already scanning", ErrInProgress, adapter.path)
+	}
+	if conn.scanning == nil {
+		conn.scanning = make(map[dbus.ObjectPath]bool)
+	}
+	conn.scanning[adapter.path] = true
+	return nil
+}
+
+func (adapter *blob) endScan() {
+	conn := adapter.conn
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	delete(conn.scanning, adapter.path)
+}
+
+func (adapter *blob) scanLoop(ctx context.Context, filter DiscoveryFilter, signals <-chan *dbus.Signal, events chan<- ScanEvent) {
+	seen := make(map[dbus.ObjectPath]bool)
+	for {
+		var s *dbus.Signal
+		var ok bool
+		select {
+		case s, ok = <-signals:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		event, ok := adapter.scanEvent(s, filter, seen)
+		if !ok {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanEvent updates the object cache according to the signal
+// and returns the corresponding event, if any.
+func (adapter *blob) scanEvent(s *dbus.Signal, filter DiscoveryFilter, seen map[dbus.ObjectPath]bool) (ScanEvent, bool) {
+	conn := adapter.conn
+	var path dbus.ObjectPath
+	var changed Properties
+	switch s.Name {
+	case interfacesAdded:
+		var dict Object
+		if dbus.Store(s.Body, &path, &dict) != nil || dict[deviceInterface] == nil {
+			return ScanEvent{}, false
+		}
+		if !inTree(path, adapter.path) {
+			return ScanEvent{}, false
+		}
+		conn.addInterfaces(path, dict)
+		changed = dict[deviceInterface]
+	case interfacesRemoved:
+		var ifaces []string
+		if dbus.Store(s.Body, &path, &ifaces) != nil || !stringsContain(ifaces, deviceInterface) {
+			return ScanEvent{}, false
+		}
+		if !seen[path] {
+			return ScanEvent{}, false
+		}
+		delete(seen, path)
+		device := conn.scannedDevice(path)
+		conn.removeInterfaces(path, ifaces)
+		if device == nil {
+			return ScanEvent{}, false
+		}
+		return ScanEvent{Type: DeviceLost, Device: device}, true
+	case propertiesChanged:
+		var iface string
+		var invalidated []string
+		path = s.Path
+		if dbus.Store(s.Body, &iface, &changed, &invalidated) != nil || iface != deviceInterface {
+			return ScanEvent{}, false
+		}
+		if !inTree(path, adapter.path) {
+			return ScanEvent{}, false
+		}
+		conn.changeProperties(path, iface, changed, invalidated)
+	default:
+		return ScanEvent{}, false
+	}
+	device := conn.scannedDevice(path)
+	if device == nil {
+		log.Printf("%s: skipping signal %s for unknown device %s", adapter.Name(), s.Name, path)
+		return ScanEvent{}, false
+	}
+	if !adapter.filterMatch(filter, device.properties) {
+		return ScanEvent{}, false
+	}
+	// The signal body is shared with other subscribers and with the cache.
+	event := ScanEvent{Type: DeviceUpdated, Device: device, Changed: copyProperties(changed)}
+	if !seen[path] {
+		seen[path] = true
+		event.Type = DeviceFound
+	}
+	return event, true
+}
+
+// scannedDevice returns the device with the given path from the object cache,
+// or nil if it is not there.
+func (conn *Connection) scannedDevice(path dbus.ObjectPath) *blob {
+	found := conn.findObjects(deviceInterface, func(device *blob) bool {
+		return device.path == path
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
@@ -0,0 +1,151 @@
+package ble
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/godbus/dbus"
+)
+
+// signalBufferSize is the number of signals that can be queued
+// for a subscriber before further ones are dropped.
+const signalBufferSize = 256
+
+// A signalQueue is a dbus.SignalHandler that preserves the order
+// in which signals arrive.  (The default handler delivers each signal
+// in its own goroutine, so they may be received in any order.)
+// Signals are queued without blocking the D-Bus connection,
+// so the consumer may make D-Bus calls while handling them.
+type signalQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*dbus.Signal
+	closed bool
+}
+
+func newSignalQueue() *signalQueue {
+	q := &signalQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// DeliverSignal implements the dbus.SignalHandler interface.
+func (q *signalQueue) DeliverSignal(iface, name string, s *dbus.Signal) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.queue = append(q.queue, s)
+	q.cond.Signal()
+}
+
+// Terminate implements the dbus.Terminator interface.
+// It is called when the D-Bus connection is closed.
+func (q *signalQueue) Terminate() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// run sends the queued signals on the given channel, in order,
+// and closes it after the queue has been terminated and drained.
+func (q *signalQueue) run(signals chan<- *dbus.Signal) {
+	for {
+		q.mu.Lock()
+		for len(q.queue) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.queue) == 0 {
+			q.mu.Unlock()
+			close(signals)
+			return
+		}
+		s := q.queue[0]
+		q.queue[0] = nil
+		q.queue = q.queue[1:]
+		q.mu.Unlock()
+		signals <- s
+	}
+}
+
+// A signalSubscriber receives the signals delivered to a Connection
+// until its subscription is canceled.
+type signalSubscriber struct {
+	signals chan *dbus.Signal
+}
+
+// subscribe returns a channel on which the signals received by the connection
+// will be delivered, and a function to cancel the subscription.
+// The channel is closed when the D-Bus connection is closed.
+// The channel is buffered; if the subscriber falls behind by more than
+// signalBufferSize signals, further ones are dropped and counted,
+// so that one subscriber cannot delay the others.
+func (conn *Connection) subscribe() (<-chan *dbus.Signal, func()) {
+	sub := &signalSubscriber{
+		signals: make(chan *dbus.Signal, signalBufferSize),
+	}
+	conn.sigMu.Lock()
+	if conn.sigClosed {
+		close(sub.signals)
+	} else {
+		conn.subscribers[sub] = struct{}{}
+	}
+	conn.sigMu.Unlock()
+	cancel := func() {
+		conn.sigMu.Lock()
+		defer conn.sigMu.Unlock()
+		delete(conn.subscribers, sub)
+	}
+	return sub.signals, cancel
+}
+
+// signalLoop dispatches the signals received by the connection
+// until the D-Bus connection is closed.
+func (conn *Connection) signalLoop(signals <-chan *dbus.Signal) {
+	for s := range signals {
+		conn.mu.RLock()
+		watching := conn.watching
+		conn.mu.RUnlock()
+		if watching {
+			conn.applySignal(s)
+		}
+		if s.Name == propertiesChanged {
+			conn.applyHandler(s)
+		}
+		for _, sub := range conn.signalSubscribers() {
+			select {
+			case sub.signals <- s:
+			default:
+				if atomic.AddUint64(&conn.signalsDropped, 1) == 1 {
+					log.Printf("dropping signals for a subscriber that is not keeping up")
+				}
+			}
+		}
+	}
+	conn.sigMu.Lock()
+	defer conn.sigMu.Unlock()
+	conn.sigClosed = true
+	for sub := range conn.subscribers {
+		close(sub.signals)
+	}
+}
+
+// SignalsDropped returns the number of signals that have been dropped
+// because a consumer, such as the receiver of a Scan channel,
+// did not keep up with them.
+func (conn *Connection) SignalsDropped() uint64 {
+	return atomic.LoadUint64(&conn.signalsDropped)
+}
+
+func (conn *Connection) signalSubscribers() []*signalSubscriber {
+	conn.sigMu.Lock()
+	defer conn.sigMu.Unlock()
+	subs := make([]*signalSubscriber, 0, len(conn.subscribers))
+	for sub := range conn.subscribers {
+		subs = append(subs, sub)
+	}
+	return subs
+}
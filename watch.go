@@ -0,0 +1,160 @@
+package ble
+
+import (
+	"log"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	interfacesRemoved = "org.freedesktop.DBus.ObjectManager.InterfacesRemoved"
+	propertiesChanged = "org.freedesktop.DBus.Properties.PropertiesChanged"
+)
+
+var watchRules = []string{
+	"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.ObjectManager'",
+	"type='signal',sender='org.bluez',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged'",
+}
+
+// Watch keeps the object cache continuously up to date,
+// using the InterfacesAdded, InterfacesRemoved, and PropertiesChanged
+// signals from BlueZ, until the connection is closed.
+// The properties of objects previously returned from the cache
+// are updated in place, so methods like Device.Connected
+// and Characteristic.Notifying return current values
+// without the need to call Update.
+func (conn *Connection) Watch() error {
+	conn.mu.Lock()
+	watching := conn.watching
+	conn.watching = true
+	conn.mu.Unlock()
+	if watching {
+		return nil
+	}
+	for _, rule := range watchRules {
+		ctx, cancel := callContext()
+		err := conn.addMatch(ctx, rule)
+		cancel()
+		if err != nil {
+			conn.mu.Lock()
+			conn.watching = false
+			conn.mu.Unlock()
+			return err
+		}
+	}
+	// Catch up with any changes that happened before the signals were matched.
+	return conn.Update()
+}
+
+// applySignal updates the object cache according to the given signal.
+// While an Update is in progress, the signal is also recorded
+// so that it can be applied again on top of the Update's snapshot.
+func (conn *Connection) applySignal(s *dbus.Signal) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.updating > 0 {
+		conn.replay = append(conn.replay, s)
+	}
+	conn.applySignalLocked(s)
+}
+
+// applySignalLocked performs applySignal.  The caller must hold conn.mu.
+// See http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-objectmanager
+// and http://dbus.freedesktop.org/doc/dbus-specification.html#standard-interfaces-properties
+func (conn *Connection) applySignalLocked(s *dbus.Signal) {
+	switch s.Name {
+	case interfacesAdded:
+		var path dbus.ObjectPath
+		var dict Object
+		err := dbus.Store(s.Body, &path, &dict)
+		if err != nil {
+			log.Printf("%s: %v", s.Name, err)
+			return
+		}
+		conn.addInterfacesLocked(path, dict)
+	case interfacesRemoved:
+		var path dbus.ObjectPath
+		var ifaces []string
+		err := dbus.Store(s.Body, &path, &ifaces)
+		if err != nil {
+			log.Printf("%s: %v", s.Name, err)
+			return
+		}
+		conn.removeInterfacesLocked(path, ifaces)
+	case propertiesChanged:
+		var iface string
+		var changed Properties
+		var invalidated []string
+		err := dbus.Store(s.Body, &iface, &changed, &invalidated)
+		if err != nil {
+			log.Printf("%s: %v", s.Name, err)
+			return
+		}
+		conn.changePropertiesLocked(s.Path, iface, changed, invalidated)
+	}
+}
+
+func (conn *Connection) addInterfaces(path dbus.ObjectPath, dict Object) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.addInterfacesLocked(path, dict)
+}
+
+// The caller must hold conn.mu.
+func (conn *Connection) addInterfacesLocked(path dbus.ObjectPath, dict Object) {
+	if conn.objects == nil {
+		conn.objects = make(map[dbus.ObjectPath]Object)
+	}
+	old := conn.objects[path]
+	if old == nil {
+		old = make(Object, len(dict))
+		conn.objects[path] = old
+	}
+	for iface, props := range dict {
+		if oldProps := old[iface]; oldProps != nil {
+			replaceProperties(oldProps, props)
+		} else {
+			old[iface] = copyProperties(props)
+		}
+	}
+}
+
+func (conn *Connection) removeInterfaces(path dbus.ObjectPath, ifaces []string) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.removeInterfacesLocked(path, ifaces)
+}
+
+// The caller must hold conn.mu.
+func (conn *Connection) removeInterfacesLocked(path dbus.ObjectPath, ifaces []string) {
+	dict := conn.objects[path]
+	if dict == nil {
+		return
+	}
+	for _, iface := range ifaces {
+		delete(dict, iface)
+	}
+	if len(dict) == 0 {
+		delete(conn.objects, path)
+	}
+}
+
+func (conn *Connection) changeProperties(path dbus.ObjectPath, iface string, changed Properties, invalidated []string) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.changePropertiesLocked(path, iface, changed, invalidated)
+}
+
+// The caller must hold conn.mu.
+func (conn *Connection) changePropertiesLocked(path dbus.ObjectPath, iface string, changed Properties, invalidated []string) {
+	props := conn.objects[path][iface]
+	if props == nil {
+		return
+	}
+	for key, val := range changed {
+		props[key] = val
+	}
+	for _, key := range invalidated {
+		delete(props, key)
+	}
+}
@@ -0,0 +1,126 @@
+package ble
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// LongWriteOptions control how WriteLong splits a value into writes.
+type LongWriteOptions struct {
+	// Type is the write type used for each fragment.
+	// If it is WriteDefault, WriteRequest is used, so that the fragments
+	// carry offsets and form a single value.
+	Type WriteType
+
+	// Interval is the time to wait between fragments,
+	// for peripherals that cannot keep up with back-to-back writes.
+	Interval time.Duration
+}
+
+// MTU returns the characteristic's ATT MTU,
+// or DefaultMTU if BlueZ does not provide it.
+func (char *blob) MTU() uint16 {
+	mtu, _ := char.property("MTU").Value().(uint16)
+	if mtu < DefaultMTU {
+		return DefaultMTU
+	}
+	return mtu
+}
+
+// writeType returns the write type to use for a single value:
+// WriteCommand if the characteristic supports write without response,
+// and WriteRequest otherwise.
+func (char *blob) writeType(t WriteType) WriteType {
+	if t != WriteDefault {
+		return t
+	}
+	if char.GattFlags().Has(FlagWriteWithoutResponse) {
+		return WriteCommand
+	}
+	return WriteRequest
+}
+
+// WriteLong writes data to the characteristic as a sequence of writes,
+// each of which fits within the MTU.  Request and reliable writes carry
+// the offset of each fragment, so the peripheral assembles the whole value;
+// write commands cannot, so if WriteCommand is requested explicitly,
+// the fragments form a stream of separate values.
+// Each fragment uses a new call timeout.
+func (char *blob) WriteLong(data []byte, opts LongWriteOptions) error {
+	return char.WriteLongContext(context.Background(), data, opts)
+}
+
+// WriteLongContext performs WriteLong, using the given context
+// for the whole sequence of writes, including the intervals between them.
+func (char *blob) WriteLongContext(ctx context.Context, data []byte, opts LongWriteOptions) error {
+	_, err := char.writeLong(ctx, data, opts)
+	return err
+}
+
+// writeLong performs WriteLongContext and returns the number of bytes written.
+func (char *blob) writeLong(ctx context.Context, data []byte, opts LongWriteOptions) (int, error) {
+	wopts := WriteOptions{Type: opts.Type}
+	if wopts.Type == WriteDefault {
+		wopts.Type = WriteRequest
+	}
+	// The ATT header is 3 bytes, plus a 2-byte offset for prepared writes.
+	size := int(char.MTU()) - 3
+	if wopts.Type == WriteReliable {
+		size -= 2
+	}
+	withOffset := wopts.Type == WriteRequest || wopts.Type == WriteReliable
+	if withOffset && len(data) > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: %d bytes exceeds maximum offset", ErrInvalidValueLength, len(data))
+	}
+	for i := 0; i < len(data); i += size {
+		if i != 0 && opts.Interval > 0 {
+			t := time.NewTimer(opts.Interval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return i, contextError(ctx)
+			}
+		}
+		end := i + size
+		if end > len(data) {
+			end = len(data)
+		}
+		if withOffset {
+			wopts.Offset = uint16(i)
+		}
+		err := char.writeFragment(ctx, data[i:end], wopts)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(data), nil
+}
+
+// writeFragment writes a single fragment, using a new call timeout
+// within the given context.
+func (char *blob) writeFragment(ctx context.Context, value []byte, opts WriteOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	return char.WriteValueWithOptionsContext(ctx, value, opts)
+}
+
+// Writer returns an io.Writer that writes to the characteristic
+// using WriteLong.  Each call to Write writes its data starting
+// at offset 0, so the Writer is a stream of values rather than
+// a single long value.
+func (char *blob) Writer(opts LongWriteOptions) io.Writer {
+	return longWriter{char: char, opts: opts}
+}
+
+type longWriter struct {
+	char *blob
+	opts LongWriteOptions
+}
+
+func (w longWriter) Write(p []byte) (int, error) {
+	return w.char.writeLong(context.Background(), p, w.opts)
+}